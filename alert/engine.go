@@ -0,0 +1,447 @@
+// Package alert evaluates alert rules against the polls of the sites and sends
+// notifications when an alert starts or stops firing. The alert state and
+// silences are kept in the site databases, so a restart neither repeats nor
+// loses notifications.
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+	"github.com/rvben/solar_exporter/services"
+	"github.com/rvben/solar_exporter/sun"
+)
+
+// Rule types.
+const (
+	// ZeroPower fires when a site reports no power while the sun is above
+	// MinElevation for For. It needs the location of the site.
+	ZeroPower = "zero_power"
+	// NoData fires when a site had no successful poll for For, counting
+	// only the hours around daylight for sites with a location.
+	NoData = "no_data"
+	// LoginFailures fires after Count failed logins in a row.
+	LoginFailures = "login_failures"
+	// LowYield fires when yesterday's energy was below Threshold times the
+	// expected energy. It needs expected_yield for the site.
+	LowYield = "low_yield"
+)
+
+// AllRules is the rule to silence to silence all rules of a site.
+const AllRules = "*"
+
+const defaultMinElevation = 10
+
+// twilight is the elevation of the sun at civil dawn and dusk.
+const twilight = -6
+
+// RuleConfig is an alert rule for Sites, or all sites when empty, notifying
+// Notifiers, or all notifiers when empty. Name defaults to the type.
+type RuleConfig struct {
+	Name         string        `yaml:"name"`
+	Type         string        `yaml:"type"`
+	Sites        []string      `yaml:"sites"`
+	For          time.Duration `yaml:"for"`
+	Count        int           `yaml:"count"`
+	Threshold    float64       `yaml:"threshold"`
+	MinElevation float64       `yaml:"min_elevation"`
+	Notifiers    []string      `yaml:"notifiers"`
+}
+
+// Config holds the alerting setup. A firing alert is notified again every
+// Repeat, or only once when Repeat is zero.
+type Config struct {
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+	Rules     []RuleConfig     `yaml:"rules"`
+	Repeat    time.Duration    `yaml:"repeat"`
+}
+
+// Alert is a notification about a rule that started or stopped firing.
+type Alert struct {
+	Rule    string    `json:"rule"`
+	Type    string    `json:"type"`
+	Site    string    `json:"site"`
+	Firing  bool      `json:"firing"`
+	Since   time.Time `json:"since"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+func (a Alert) Title() string {
+	state := "RESOLVED"
+	if a.Firing {
+		state = "FIRING"
+	}
+	return fmt.Sprintf("[%s] %s: %s", state, a.Site, a.Rule)
+}
+
+type siteState struct {
+	mu sync.Mutex
+	db *models.DataBase
+	// lat and lon are the location of the site when located is set.
+	lat, lon      float64
+	located       bool
+	lastSuccess   time.Time
+	zeroSince     time.Time
+	loginFailures int
+	// polled and reported tell whether a poll, and a poll with a status,
+	// happened since the start, so a restart does not resolve alerts it
+	// knows nothing about yet.
+	polled   bool
+	reported bool
+	// sending holds the rules with a notification underway.
+	sending map[string]bool
+}
+
+// delivery is a notification to send for a rule, for the alert state it
+// belongs to.
+type delivery struct {
+	rule  RuleConfig
+	alert Alert
+	state models.AlertState
+}
+
+// Engine evaluates the rules for the sites added to it.
+type Engine struct {
+	rules     []RuleConfig
+	notifiers map[string]Notifier
+	repeat    time.Duration
+	sites     map[string]*siteState
+	now       func() time.Time
+}
+
+func NewEngine(config Config) (*Engine, error) {
+	e := &Engine{notifiers: map[string]Notifier{}, repeat: config.Repeat, sites: map[string]*siteState{}, now: time.Now}
+	for _, c := range config.Notifiers {
+		if _, ok := e.notifiers[c.Name]; ok || c.Name == "" {
+			return nil, fmt.Errorf("notifier names must be unique and not empty: [%s]", c.Name)
+		}
+		n, err := NewNotifier(c)
+		if err != nil {
+			return nil, err
+		}
+		e.notifiers[c.Name] = n
+	}
+	names := map[string]bool{}
+	for _, r := range config.Rules {
+		if r.Name == "" {
+			r.Name = r.Type
+		}
+		if names[r.Name] || r.Name == AllRules {
+			return nil, fmt.Errorf("rule names must be unique: [%s]", r.Name)
+		}
+		names[r.Name] = true
+		switch r.Type {
+		case ZeroPower, NoData:
+			if r.For <= 0 {
+				return nil, fmt.Errorf("rule [%s] needs a positive for", r.Name)
+			}
+		case LoginFailures:
+			if r.Count <= 0 {
+				r.Count = 1
+			}
+		case LowYield:
+			if r.Threshold <= 0 {
+				return nil, fmt.Errorf("rule [%s] needs a positive threshold", r.Name)
+			}
+		default:
+			return nil, fmt.Errorf("rule [%s] has unknown type [%s]", r.Name, r.Type)
+		}
+		if r.Type == ZeroPower && r.MinElevation == 0 {
+			r.MinElevation = defaultMinElevation
+		}
+		for _, n := range r.Notifiers {
+			if _, ok := e.notifiers[n]; !ok {
+				return nil, fmt.Errorf("rule [%s] has unknown notifier [%s]", r.Name, n)
+			}
+		}
+		e.rules = append(e.rules, r)
+	}
+	return e, nil
+}
+
+// AddSite adds a site to evaluate the rules for. The metadata may be nil; zero
+// power rules do not apply to sites without a location.
+func (e *Engine) AddSite(site string, db *models.DataBase, metadata *models.SiteMetadata) {
+	s := &siteState{db: db, lastSuccess: e.now(), sending: map[string]bool{}}
+	if metadata != nil {
+		s.lat, s.lon, s.located = metadata.Location()
+	}
+	e.sites[site] = s
+}
+
+// Check verifies that the sites named by the rules were added and have what
+// the rules need.
+func (e *Engine) Check() error {
+	for _, r := range e.rules {
+		for _, site := range r.Sites {
+			s, ok := e.sites[site]
+			if !ok {
+				return fmt.Errorf("rule [%s] has unknown site [%s]", r.Name, site)
+			}
+			if r.Type == ZeroPower && !s.located {
+				return fmt.Errorf("rule [%s] needs the lat and lon of [%s] under sites", r.Name, site)
+			}
+		}
+	}
+	return nil
+}
+
+func (r RuleConfig) appliesTo(site string) bool {
+	if len(r.Sites) == 0 {
+		return true
+	}
+	for _, s := range r.Sites {
+		if s == site {
+			return true
+		}
+	}
+	return false
+}
+
+// Observe records the outcome of a poll of site and evaluates its rules.
+func (e *Engine) Observe(site string, status *models.SolarStatus, err error) {
+	s, ok := e.sites[site]
+	if !ok {
+		return
+	}
+	now := e.now()
+	s.mu.Lock()
+	s.polled = true
+	var loginErr *services.LoginError
+	switch {
+	case errors.As(err, &loginErr):
+		s.loginFailures++
+	case err != nil:
+		s.loginFailures = 0
+	default:
+		s.loginFailures = 0
+		s.lastSuccess = now
+	}
+	if status != nil {
+		s.reported = true
+		if status.PowerNow > 0 {
+			s.zeroSince = time.Time{}
+		} else if s.zeroSince.IsZero() {
+			s.zeroSince = now
+		}
+	}
+	deliveries := e.evaluate(site, s, now)
+	s.mu.Unlock()
+	e.deliver(s, deliveries)
+}
+
+// Evaluate evaluates the rules of all sites, for the rules that can fire
+// without a poll, like no data.
+func (e *Engine) Evaluate() {
+	now := e.now()
+	for site, s := range e.sites {
+		s.mu.Lock()
+		deliveries := e.evaluate(site, s, now)
+		s.mu.Unlock()
+		e.deliver(s, deliveries)
+	}
+}
+
+// daylight returns how long the sun was above minElevation between from and
+// to, counting no further than limit.
+func (s *siteState) daylight(from, to time.Time, minElevation float64, limit time.Duration) time.Duration {
+	const step = 5 * time.Minute
+	daylight := time.Duration(0)
+	for t := from; t.Before(to) && daylight < limit; t = t.Add(step) {
+		if sun.PositionAt(t, s.lat, s.lon).Elevation > minElevation {
+			daylight += step
+		}
+	}
+	return daylight
+}
+
+// condition returns whether rule fires for the site, with a message, or false
+// for ok when the rule cannot be evaluated for the site.
+func (s *siteState) condition(r RuleConfig, now time.Time) (firing bool, message string, ok bool) {
+	switch r.Type {
+	case ZeroPower:
+		if !s.located || !s.reported {
+			return false, "", false
+		}
+		if s.zeroSince.IsZero() {
+			return false, "", true
+		}
+		// Only daylight counts, and only power clears the alert: a dead
+		// inverter stays dead overnight and across restarts.
+		if s.daylight(s.zeroSince, now, r.MinElevation, r.For) < r.For {
+			return false, "", false
+		}
+		return true, fmt.Sprintf("No power during %s of daylight since %s", r.For, s.zeroSince.Format(time.RFC3339)), true
+	case NoData:
+		// Sites may not be polled at night, so with a location only the
+		// hours around daylight count.
+		elapsed := now.Sub(s.lastSuccess)
+		if s.located {
+			elapsed = s.daylight(s.lastSuccess, now, twilight, r.For)
+		}
+		return elapsed >= r.For, fmt.Sprintf("No data since %s", s.lastSuccess.Format(time.RFC3339)), true
+	case LoginFailures:
+		if !s.polled {
+			return false, "", false
+		}
+		return s.loginFailures >= r.Count, fmt.Sprintf("%d failed logins in a row, check the credentials", s.loginFailures), true
+	case LowYield:
+		yields, err := s.db.GetExpectedYields(now.Format("2006-01-02"), 1)
+		if err != nil || len(yields) == 0 || yields[0].Date != now.AddDate(0, 0, -1).Format("2006-01-02") {
+			return false, "", false
+		}
+		ratio, ok := yields[0].Ratio()
+		if !ok {
+			return false, "", false
+		}
+		return ratio < r.Threshold, fmt.Sprintf("Yesterday's energy was %.0f%% of the expected energy", ratio*100), true
+	}
+	return false, "", false
+}
+
+// evaluate updates the alert states of the site and returns the notifications
+// to send. The caller holds s.mu and sends them once it is released, so a slow
+// notifier does not hold up the polls of the site.
+func (e *Engine) evaluate(site string, s *siteState, now time.Time) []delivery {
+	silences, err := s.db.GetSilences()
+	if err != nil {
+		log.Printf("%s - Could not read the alert silences: %s", site, err)
+	}
+	// The states are saved with a precision of seconds.
+	now = now.Truncate(time.Second)
+	var deliveries []delivery
+	for _, r := range e.rules {
+		if !r.appliesTo(site) || s.sending[r.Name] {
+			continue
+		}
+		firing, message, ok := s.condition(r, now)
+		if !ok {
+			continue
+		}
+		state, found, err := s.db.GetAlertState(r.Name)
+		if err != nil {
+			log.Printf("%s - Could not read the state of alert [%s]: %s", site, r.Name, err)
+			continue
+		}
+		if !found && !firing {
+			continue
+		}
+		silenced := now.Before(silences[r.Name]) || now.Before(silences[AllRules])
+
+		next := state
+		if !found || state.Firing != firing {
+			next = models.AlertState{Rule: r.Name, Firing: firing, Since: now}
+		}
+		notify := false
+		switch {
+		case firing:
+			notify = !silenced && (next.Notified.IsZero() || (e.repeat > 0 && now.Sub(next.Notified) >= e.repeat))
+		case state.Firing:
+			// Only resolve what was notified.
+			notify = !silenced && !state.Notified.IsZero()
+		}
+		if notify {
+			a := Alert{Rule: r.Name, Type: r.Type, Site: site, Firing: firing, Since: next.Since, Time: now, Message: message}
+			if !firing {
+				a.Since = state.Since
+				a.Message = fmt.Sprintf("Resolved after %s", now.Sub(state.Since).Round(time.Minute))
+			}
+			s.sending[r.Name] = true
+			deliveries = append(deliveries, delivery{rule: r, alert: a, state: next})
+		}
+		if next != state || !found {
+			if err := s.db.SaveAlertState(next); err != nil {
+				log.Printf("%s - Could not save the state of alert [%s]: %s", site, r.Name, err)
+			}
+		}
+	}
+	return deliveries
+}
+
+// deliver sends the notifications and marks the alert states they belong to
+// as notified, unless the state changed in the meantime.
+func (e *Engine) deliver(s *siteState, deliveries []delivery) {
+	for _, d := range deliveries {
+		sent := e.notify(d.rule, d.alert)
+
+		s.mu.Lock()
+		delete(s.sending, d.rule.Name)
+		if sent {
+			state, found, err := s.db.GetAlertState(d.rule.Name)
+			if err == nil && found && state.Firing == d.state.Firing && state.Since.Equal(d.state.Since) {
+				state.Notified = d.alert.Time
+				err = s.db.SaveAlertState(state)
+			}
+			if err != nil {
+				log.Printf("%s - Could not save the state of alert [%s]: %s", d.alert.Site, d.rule.Name, err)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// notify sends a to the notifiers of the rule and reports whether any of them
+// succeeded; otherwise it is sent again at the next evaluation.
+func (e *Engine) notify(r RuleConfig, a Alert) bool {
+	names := r.Notifiers
+	if len(names) == 0 {
+		for name := range e.notifiers {
+			names = append(names, name)
+		}
+	}
+	sent := false
+	for _, name := range names {
+		if err := e.notifiers[name].Notify(a); err != nil {
+			log.Printf("%s - Could not send alert [%s] with notifier [%s]: %s", a.Site, a.Rule, name, err)
+			continue
+		}
+		log.Printf("%s - Sent %s with notifier [%s]", a.Site, a.Title(), name)
+		sent = true
+	}
+	return sent
+}
+
+// Silence silences rule, or all rules with AllRules, of site until the given
+// time.
+func (e *Engine) Silence(site, rule string, until time.Time) error {
+	s, ok := e.sites[site]
+	if !ok {
+		return fmt.Errorf("unknown site [%s]", site)
+	}
+	if rule != AllRules {
+		found := false
+		for _, r := range e.rules {
+			found = found || r.Name == rule
+		}
+		if !found {
+			return fmt.Errorf("unknown rule [%s]", rule)
+		}
+	}
+	return s.db.SaveSilence(rule, until)
+}
+
+// Silences returns the active silences of every site by rule.
+func (e *Engine) Silences() (map[string]map[string]time.Time, error) {
+	now := e.now()
+	result := map[string]map[string]time.Time{}
+	for site, s := range e.sites {
+		silences, err := s.db.GetSilences()
+		if err != nil {
+			return nil, err
+		}
+		for rule, until := range silences {
+			if until.After(now) {
+				if result[site] == nil {
+					result[site] = map[string]time.Time{}
+				}
+				result[site][rule] = until
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,247 @@
+package alert
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+	"github.com/rvben/solar_exporter/services"
+)
+
+type fakeNotifier struct {
+	alerts []Alert
+	err    error
+}
+
+func (n *fakeNotifier) Notify(a Alert) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.alerts = append(n.alerts, a)
+	return nil
+}
+
+var testLat, testLon = 52.37, 4.89
+
+var testSite = models.SiteMetadata{Site: "test", KWp: 4, Lat: &testLat, Lon: &testLon}
+
+func testDB(t *testing.T) *models.DataBase {
+	db, err := models.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+	return db
+}
+
+// testEngine returns an engine with a fake notifier and the test site, with
+// the clock at *now.
+func testEngine(t *testing.T, db *models.DataBase, now *time.Time, config Config) (*Engine, *fakeNotifier) {
+	e, err := NewEngine(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNotifier{}
+	e.notifiers["fake"] = n
+	e.now = func() time.Time { return *now }
+	e.AddSite("test", db, &testSite)
+	if err := e.Check(); err != nil {
+		t.Fatal(err)
+	}
+	return e, n
+}
+
+func TestZeroPower(t *testing.T) {
+	now := time.Date(2024, 6, 21, 10, 0, 0, 0, time.UTC)
+	db := testDB(t)
+	e, n := testEngine(t, db, &now, Config{Rules: []RuleConfig{{Type: ZeroPower, For: 30 * time.Minute}}})
+
+	e.Observe("test", &models.SolarStatus{PowerNow: 0}, nil)
+	now = now.Add(20 * time.Minute)
+	e.Observe("test", &models.SolarStatus{PowerNow: 0}, nil)
+	if len(n.alerts) != 0 {
+		t.Fatalf("Expected no alert before 30 minutes, got %+v", n.alerts)
+	}
+	now = now.Add(20 * time.Minute)
+	e.Observe("test", &models.SolarStatus{PowerNow: 0}, nil)
+	now = now.Add(10 * time.Minute)
+	e.Evaluate()
+	if len(n.alerts) != 1 || !n.alerts[0].Firing || n.alerts[0].Rule != ZeroPower {
+		t.Fatalf("Expected a single firing alert, got %+v", n.alerts)
+	}
+
+	// A restart neither repeats nor resolves the alert.
+	e, n = testEngine(t, db, &now, Config{Rules: []RuleConfig{{Type: ZeroPower, For: 30 * time.Minute}}})
+	e.Evaluate()
+	e.Observe("test", &models.SolarStatus{PowerNow: 0}, nil)
+	if len(n.alerts) != 0 {
+		t.Fatalf("Expected no alert after a restart, got %+v", n.alerts)
+	}
+	now = now.Add(time.Minute)
+	e.Observe("test", &models.SolarStatus{PowerNow: 500}, nil)
+	if len(n.alerts) != 1 || n.alerts[0].Firing {
+		t.Fatalf("Expected a resolved alert, got %+v", n.alerts)
+	}
+}
+
+func TestZeroPowerAtNight(t *testing.T) {
+	now := time.Date(2024, 6, 21, 21, 0, 0, 0, time.UTC)
+	e, n := testEngine(t, testDB(t), &now, Config{Rules: []RuleConfig{{Type: ZeroPower, For: 30 * time.Minute}}})
+	for i := 0; i < 12; i++ {
+		e.Observe("test", &models.SolarStatus{PowerNow: 0}, nil)
+		now = now.Add(20 * time.Minute)
+	}
+	if len(n.alerts) != 0 {
+		t.Fatalf("Expected no alert at night, got %+v", n.alerts)
+	}
+}
+
+func TestNoDataAndRepeat(t *testing.T) {
+	now := time.Date(2024, 6, 21, 10, 0, 0, 0, time.UTC)
+	e, n := testEngine(t, testDB(t), &now, Config{Rules: []RuleConfig{{Type: NoData, For: time.Hour}}, Repeat: 6 * time.Hour})
+
+	now = now.Add(30 * time.Minute)
+	e.Observe("test", nil, fmt.Errorf("timeout"))
+	now = now.Add(time.Hour)
+	e.Evaluate()
+	e.Evaluate()
+	if len(n.alerts) != 1 || !n.alerts[0].Firing {
+		t.Fatalf("Expected a single firing alert, got %+v", n.alerts)
+	}
+	now = now.Add(6 * time.Hour)
+	e.Evaluate()
+	if len(n.alerts) != 2 {
+		t.Fatalf("Expected the alert to repeat, got %+v", n.alerts)
+	}
+	e.Observe("test", &models.SolarStatus{PowerNow: 0}, nil)
+	if len(n.alerts) != 3 || n.alerts[2].Firing {
+		t.Fatalf("Expected a resolved alert, got %+v", n.alerts)
+	}
+}
+
+func TestLoginFailuresAndSilence(t *testing.T) {
+	now := time.Date(2024, 6, 21, 10, 0, 0, 0, time.UTC)
+	e, n := testEngine(t, testDB(t), &now, Config{Rules: []RuleConfig{{Name: "login", Type: LoginFailures, Count: 2}}})
+
+	if err := e.Silence("test", AllRules, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Silence("test", "unknown", now.Add(time.Hour)); err == nil {
+		t.Errorf("Expected an error silencing an unknown rule")
+	}
+	loginErr := &services.LoginError{Site: "test", User: "user", Err: fmt.Errorf("wrong password")}
+	e.Observe("test", nil, loginErr)
+	e.Observe("test", nil, fmt.Errorf("failed: %w", loginErr))
+	if len(n.alerts) != 0 {
+		t.Fatalf("Expected no alert while silenced, got %+v", n.alerts)
+	}
+	silences, _ := e.Silences()
+	if _, ok := silences["test"][AllRules]; !ok {
+		t.Errorf("Expected an active silence, got %v", silences)
+	}
+
+	now = now.Add(2 * time.Hour)
+	e.Evaluate()
+	if len(n.alerts) != 1 || !n.alerts[0].Firing {
+		t.Fatalf("Expected the alert once the silence ended, got %+v", n.alerts)
+	}
+}
+
+func TestLowYieldAndFailingNotifier(t *testing.T) {
+	now := time.Date(2024, 6, 21, 10, 0, 0, 0, time.UTC)
+	db := testDB(t)
+	e, n := testEngine(t, db, &now, Config{Rules: []RuleConfig{{Type: LowYield, Threshold: 0.5}}})
+
+	db.SaveExpectedYield(models.ExpectedYield{Date: "2024-06-20", Expected: 30000, Actual: 9000})
+	n.err = fmt.Errorf("unreachable")
+	e.Evaluate()
+	n.err = nil
+	e.Evaluate()
+	if len(n.alerts) != 1 || !n.alerts[0].Firing || n.alerts[0].Message != "Yesterday's energy was 30% of the expected energy" {
+		t.Fatalf("Expected the alert once the notifier works, got %+v", n.alerts)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	for _, c := range []Config{
+		{Rules: []RuleConfig{{Type: "unknown"}}},
+		{Rules: []RuleConfig{{Type: NoData}}},
+		{Rules: []RuleConfig{{Type: LowYield}}},
+		{Rules: []RuleConfig{{Type: NoData, For: time.Hour}, {Type: NoData, For: time.Hour}}},
+		{Rules: []RuleConfig{{Type: LoginFailures, Notifiers: []string{"missing"}}}},
+		{Notifiers: []NotifierConfig{{Name: "x", Type: "pager"}}},
+	} {
+		if _, err := NewEngine(c); err == nil {
+			t.Errorf("Expected %+v to be invalid", c)
+		}
+	}
+
+	e, _ := NewEngine(Config{Rules: []RuleConfig{{Type: ZeroPower, For: time.Hour, Sites: []string{"test"}}}})
+	e.AddSite("test", testDB(t), nil)
+	if err := e.Check(); err == nil {
+		t.Errorf("Expected an error for a zero power rule without site metadata")
+	}
+	e.AddSite("test", testDB(t), &models.SiteMetadata{Site: "test", KWp: 4})
+	if err := e.Check(); err == nil {
+		t.Errorf("Expected an error for a zero power rule without a location")
+	}
+}
+
+func TestNoDataAtNight(t *testing.T) {
+	now := time.Date(2024, 12, 21, 17, 0, 0, 0, time.UTC)
+	e, n := testEngine(t, testDB(t), &now, Config{Rules: []RuleConfig{{Type: NoData, For: 3 * time.Hour}}})
+	e.Observe("test", &models.SolarStatus{}, nil)
+
+	// Not polled between dusk and dawn.
+	now = now.Add(14 * time.Hour)
+	e.Evaluate()
+	if len(n.alerts) != 0 {
+		t.Fatalf("Expected no alert at night, got %+v", n.alerts)
+	}
+	now = now.Add(4 * time.Hour)
+	e.Evaluate()
+	if len(n.alerts) != 1 {
+		t.Fatalf("Expected an alert after hours of daylight without data, got %+v", n.alerts)
+	}
+}
+
+type blockingNotifier struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (n *blockingNotifier) Notify(a Alert) error {
+	n.started <- struct{}{}
+	<-n.release
+	return nil
+}
+
+func TestSlowNotifier(t *testing.T) {
+	now := time.Date(2024, 6, 21, 10, 0, 0, 0, time.UTC)
+	e, _ := testEngine(t, testDB(t), &now, Config{Rules: []RuleConfig{{Type: NoData, For: time.Hour}}})
+	n := &blockingNotifier{started: make(chan struct{}, 2), release: make(chan struct{})}
+	e.notifiers = map[string]Notifier{"slow": n}
+
+	now = now.Add(2 * time.Hour)
+	go e.Evaluate()
+	<-n.started
+
+	// The site keeps polling while the notification is underway, without
+	// sending it twice.
+	observed := make(chan struct{})
+	go func() {
+		e.Observe("test", nil, fmt.Errorf("timeout"))
+		close(observed)
+	}()
+	select {
+	case <-observed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a poll not to wait for the notifier")
+	}
+	close(n.release)
+	if len(n.started) != 0 {
+		t.Errorf("Expected a single notification")
+	}
+}
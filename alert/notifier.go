@@ -0,0 +1,225 @@
+package alert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// notifierTimeout bounds the time a notifier may take to send an alert.
+const notifierTimeout = 30 * time.Second
+
+// Notifier sends alerts somewhere a person will see them.
+type Notifier interface {
+	Notify(a Alert) error
+}
+
+// NotifierConfig configures a notifier of Type webhook, email, ntfy or
+// telegram; each type uses its own subset of the fields.
+//
+// webhook posts the alert as JSON to URL. email sends it over SMTP through
+// Server (host:port) from From to To, logging in when Username is set. ntfy
+// publishes it to the topic at URL, with Token as access token if set.
+// telegram sends it to ChatID with the bot Token.
+type NotifierConfig struct {
+	Name     string            `yaml:"name"`
+	Type     string            `yaml:"type"`
+	URL      string            `yaml:"url"`
+	Headers  map[string]string `yaml:"headers"`
+	Token    string            `yaml:"token"`
+	ChatID   string            `yaml:"chat_id"`
+	Server   string            `yaml:"server"`
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+	From     string            `yaml:"from"`
+	To       []string          `yaml:"to"`
+}
+
+// NewNotifier returns the notifier described by config.
+func NewNotifier(config NotifierConfig) (Notifier, error) {
+	client := &http.Client{Timeout: notifierTimeout}
+	switch config.Type {
+	case "webhook":
+		if config.URL == "" {
+			return nil, fmt.Errorf("notifier [%s] needs a url", config.Name)
+		}
+		return &WebhookNotifier{url: config.URL, headers: config.Headers, client: client}, nil
+	case "email":
+		if config.Server == "" || config.From == "" || len(config.To) == 0 {
+			return nil, fmt.Errorf("notifier [%s] needs a server, from and to", config.Name)
+		}
+		if _, _, err := net.SplitHostPort(config.Server); err != nil {
+			return nil, fmt.Errorf("notifier [%s] needs a server as host:port: %s", config.Name, err)
+		}
+		return &EmailNotifier{server: config.Server, username: config.Username, password: config.Password, from: config.From, to: config.To, timeout: notifierTimeout}, nil
+	case "ntfy":
+		if config.URL == "" {
+			return nil, fmt.Errorf("notifier [%s] needs the url of the topic", config.Name)
+		}
+		return &NtfyNotifier{url: config.URL, token: config.Token, client: client}, nil
+	case "telegram":
+		if config.Token == "" || config.ChatID == "" {
+			return nil, fmt.Errorf("notifier [%s] needs a token and chat_id", config.Name)
+		}
+		url := config.URL
+		if url == "" {
+			url = "https://api.telegram.org"
+		}
+		return &TelegramNotifier{url: url, token: config.Token, chatID: config.ChatID, client: client}, nil
+	}
+	return nil, fmt.Errorf("notifier [%s] has unknown type [%s]", config.Name, config.Type)
+}
+
+// post sends req and fails on any status but 2xx.
+func post(client *http.Client, req *http.Request) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could succesfully finish request [%s]: %s", req.URL, err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+	return nil
+}
+
+type WebhookNotifier struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (n *WebhookNotifier) Notify(a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not create request for url [%s]: %s", n.url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.headers {
+		req.Header.Set(k, v)
+	}
+	return post(n.client, req)
+}
+
+type NtfyNotifier struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (n *NtfyNotifier) Notify(a Alert) error {
+	req, err := http.NewRequest("POST", n.url, strings.NewReader(a.Message))
+	if err != nil {
+		return fmt.Errorf("could not create request for url [%s]: %s", n.url, err)
+	}
+	req.Header.Set("Title", a.Title())
+	if a.Firing {
+		req.Header.Set("Priority", "high")
+		req.Header.Set("Tags", "warning")
+	} else {
+		req.Header.Set("Tags", "white_check_mark")
+	}
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	return post(n.client, req)
+}
+
+type TelegramNotifier struct {
+	url    string
+	token  string
+	chatID string
+	client *http.Client
+}
+
+func (n *TelegramNotifier) Notify(a Alert) error {
+	body, err := json.Marshal(map[string]string{"chat_id": n.chatID, "text": a.Title() + "\n" + a.Message})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/bot%s/sendMessage", n.url, n.token)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		// The url holds the bot token, so it is left out.
+		return fmt.Errorf("could not create telegram request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := post(n.client, req); err != nil {
+		return fmt.Errorf("telegram: %s", strings.ReplaceAll(err.Error(), n.token, "REDACTED"))
+	}
+	return nil
+}
+
+type EmailNotifier struct {
+	server   string
+	username string
+	password string
+	from     string
+	to       []string
+	timeout  time.Duration
+}
+
+func (n *EmailNotifier) Notify(a Alert) error {
+	host, _, err := net.SplitHostPort(n.server)
+	if err != nil {
+		return fmt.Errorf("invalid smtp server [%s]: %s", n.server, err)
+	}
+	conn, err := net.DialTimeout("tcp", n.server, n.timeout)
+	if err != nil {
+		return fmt.Errorf("could not connect to smtp server [%s]: %s", n.server, err)
+	}
+	defer conn.Close()
+	// The deadline covers the whole conversation, so a server that stops
+	// responding cannot hold up the alerts.
+	if err := conn.SetDeadline(time.Now().Add(n.timeout)); err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return fmt.Errorf("smtp server [%s]: %s", n.server, err)
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("smtp server [%s]: %s", n.server, err)
+		}
+	}
+	if n.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.username, n.password, host)); err != nil {
+			return fmt.Errorf("smtp server [%s]: %s", n.server, err)
+		}
+	}
+	if err := c.Mail(n.from); err != nil {
+		return fmt.Errorf("smtp server [%s]: %s", n.server, err)
+	}
+	for _, to := range n.to {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("smtp server [%s]: %s", n.server, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("smtp server [%s]: %s", n.server, err)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		n.from, strings.Join(n.to, ", "), a.Title(), a.Time.Format(time.RFC1123Z), a.Message)
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return fmt.Errorf("smtp server [%s]: %s", n.server, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp server [%s]: %s", n.server, err)
+	}
+	return c.Quit()
+}
@@ -0,0 +1,156 @@
+package alert
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testAlert = Alert{Rule: "dead", Type: ZeroPower, Site: "test", Firing: true, Time: time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC), Message: "No power"}
+
+func TestNotifiers(t *testing.T) {
+	var got *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer server.Close()
+
+	n, _ := NewNotifier(NotifierConfig{Name: "hook", Type: "webhook", URL: server.URL + "/hook", Headers: map[string]string{"X-Key": "secret"}})
+	if err := n.Notify(testAlert); err != nil {
+		t.Fatal(err)
+	}
+	var a Alert
+	if err := json.Unmarshal([]byte(body), &a); err != nil || a.Rule != "dead" || got.Header.Get("X-Key") != "secret" {
+		t.Errorf("Expected the alert as JSON with the headers, got %s %v", body, got.Header)
+	}
+
+	n, _ = NewNotifier(NotifierConfig{Name: "phone", Type: "ntfy", URL: server.URL + "/solar", Token: "tk"})
+	if err := n.Notify(testAlert); err != nil {
+		t.Fatal(err)
+	}
+	if got.URL.Path != "/solar" || body != "No power" || got.Header.Get("Title") != "[FIRING] test: dead" || got.Header.Get("Authorization") != "Bearer tk" {
+		t.Errorf("Unexpected ntfy request %s %s %v", got.URL, body, got.Header)
+	}
+
+	n, _ = NewNotifier(NotifierConfig{Name: "tg", Type: "telegram", URL: server.URL, Token: "123:abc", ChatID: "42"})
+	if err := n.Notify(testAlert); err != nil {
+		t.Fatal(err)
+	}
+	if got.URL.Path != "/bot123:abc/sendMessage" || !strings.Contains(body, `"chat_id":"42"`) {
+		t.Errorf("Unexpected telegram request %s %s", got.URL, body)
+	}
+}
+
+func TestNotifierErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	n, _ := NewNotifier(NotifierConfig{Name: "tg", Type: "telegram", URL: server.URL, Token: "123:abc", ChatID: "42"})
+	err := n.Notify(testAlert)
+	if err == nil || strings.Contains(err.Error(), "123:abc") {
+		t.Errorf("Expected an error without the token, got %v", err)
+	}
+
+	for _, c := range []NotifierConfig{
+		{Name: "hook", Type: "webhook"},
+		{Name: "mail", Type: "email", Server: "smtp.example.com:587"},
+		{Name: "phone", Type: "ntfy"},
+		{Name: "tg", Type: "telegram", Token: "123:abc"},
+	} {
+		if _, err := NewNotifier(c); err == nil {
+			t.Errorf("Expected notifier [%s] to be invalid", c.Name)
+		}
+	}
+}
+
+// fakeSMTP serves a minimal SMTP conversation on a local port and returns its
+// address and the received message. Without respond it accepts connections
+// but never answers.
+func fakeSMTP(t *testing.T, respond bool) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if !respond {
+			time.Sleep(time.Second)
+			return
+		}
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case inData && line == ".\r\n":
+				inData = false
+				messages <- data.String()
+				fmt.Fprint(conn, "250 OK\r\n")
+			case inData:
+				data.WriteString(line)
+			case strings.HasPrefix(line, "EHLO"):
+				fmt.Fprint(conn, "250 localhost\r\n")
+			case strings.HasPrefix(line, "DATA"):
+				inData = true
+				fmt.Fprint(conn, "354 Go ahead\r\n")
+			case strings.HasPrefix(line, "QUIT"):
+				fmt.Fprint(conn, "221 Bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "250 OK\r\n")
+			}
+		}
+	}()
+	return l.Addr().String(), messages
+}
+
+func TestEmailNotifier(t *testing.T) {
+	addr, messages := fakeSMTP(t, true)
+	n, err := NewNotifier(NotifierConfig{Name: "mail", Type: "email", Server: addr, From: "solar@example.com", To: []string{"ops@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(testAlert); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-messages; !strings.Contains(msg, "Subject: [FIRING] test: dead") || !strings.Contains(msg, "No power") {
+		t.Errorf("Unexpected message %q", msg)
+	}
+}
+
+func TestEmailNotifierTimeout(t *testing.T) {
+	addr, _ := fakeSMTP(t, false)
+	n, _ := NewNotifier(NotifierConfig{Name: "mail", Type: "email", Server: addr, From: "solar@example.com", To: []string{"ops@example.com"}})
+	n.(*EmailNotifier).timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if err := n.Notify(testAlert); err == nil {
+		t.Errorf("Expected an error from a server that does not respond")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the notifier to give up after the timeout, took %s", elapsed)
+	}
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,6 +12,10 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -16,8 +23,12 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rvben/solar_exporter/alert"
 	"github.com/rvben/solar_exporter/models"
+	"github.com/rvben/solar_exporter/performance"
+	"github.com/rvben/solar_exporter/schedule"
 	"github.com/rvben/solar_exporter/services"
+	"github.com/rvben/solar_exporter/tariff"
 	"gopkg.in/yaml.v2"
 )
 
@@ -64,21 +75,730 @@ var (
 		},
 		[]string{"site"},
 	)
+	gridPowerImport = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_grid_power_import",
+			Help: "Power imported from the grid in W",
+		},
+		[]string{"site"},
+	)
+	gridPowerExport = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_grid_power_export",
+			Help: "Power exported to the grid in W",
+		},
+		[]string{"site"},
+	)
+	gridEnergyImport = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_grid_energy_import",
+			Help: "Energy imported from the grid in Wh",
+		},
+		[]string{"site", "tariff"},
+	)
+	gridEnergyExport = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_grid_energy_export",
+			Help: "Energy exported to the grid in Wh",
+		},
+		[]string{"site", "tariff"},
+	)
+	loadPowerNow = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_load_power_now",
+			Help: "Power consumed by the site in W",
+		},
+		[]string{"site"},
+	)
+	loadEnergyTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_load_energy_total",
+			Help: "Total Energy consumed by the site in Wh",
+		},
+		[]string{"site"},
+	)
+	batteryPowerCharge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_battery_power_charge",
+			Help: "Power charging the batteries in W",
+		},
+		[]string{"site"},
+	)
+	batteryPowerDischarge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_battery_power_discharge",
+			Help: "Power discharged from the batteries in W",
+		},
+		[]string{"site"},
+	)
+	batteryStateOfCharge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_battery_state_of_charge",
+			Help: "State of charge of the batteries in percent",
+		},
+		[]string{"site"},
+	)
+	batteryEnergyCharged = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_battery_energy_charged",
+			Help: "Total Energy charged into the batteries in Wh",
+		},
+		[]string{"site"},
+	)
+	batteryEnergyDischarged = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_battery_energy_discharged",
+			Help: "Total Energy discharged from the batteries in Wh",
+		},
+		[]string{"site"},
+	)
+	incomeToday = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_income_today",
+			Help: "Today's Income",
+		},
+		[]string{"site", "currency"},
+	)
+	incomeMonth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_income_month",
+			Help: "This Month's Income",
+		},
+		[]string{"site", "currency"},
+	)
+	incomeYear = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_income_year",
+			Help: "This Year's Income",
+		},
+		[]string{"site", "currency"},
+	)
+	incomeTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_income_total",
+			Help: "Total Income",
+		},
+		[]string{"site", "currency"},
+	)
+	co2Avoided = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_co2_avoided_kg",
+			Help: "CO2 emissions avoided in kg",
+		},
+		[]string{"site"},
+	)
+	treesPlanted = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_trees_planted",
+			Help: "Equivalent number of trees planted",
+		},
+		[]string{"site"},
+	)
+	savingsToday = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_savings_today",
+			Help: "Today's money saved by self-consumption, from the configured tariff",
+		},
+		[]string{"site", "currency"},
+	)
+	savingsMonth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_savings_month",
+			Help: "This Month's money saved by self-consumption, from the configured tariff",
+		},
+		[]string{"site", "currency"},
+	)
+	savingsYear = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_savings_year",
+			Help: "This Year's money saved by self-consumption, from the configured tariff",
+		},
+		[]string{"site", "currency"},
+	)
+	earningsToday = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_earnings_today",
+			Help: "Today's money earned by exporting, from the configured tariff",
+		},
+		[]string{"site", "currency"},
+	)
+	earningsMonth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_earnings_month",
+			Help: "This Month's money earned by exporting, from the configured tariff",
+		},
+		[]string{"site", "currency"},
+	)
+	earningsYear = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_earnings_year",
+			Help: "This Year's money earned by exporting, from the configured tariff",
+		},
+		[]string{"site", "currency"},
+	)
+	siteCapacity = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_site_capacity_kwp",
+			Help: "Installed capacity of the site in kWp",
+		},
+		[]string{"site"},
+	)
+	siteInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_site_info",
+			Help: "Metadata of the site, always 1",
+		},
+		[]string{"site", "tilt", "azimuth", "lat", "lon", "commissioned"},
+	)
+	specificYieldToday = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_specific_yield_today",
+			Help: "Today's energy per installed capacity in kWh/kWp",
+		},
+		[]string{"site"},
+	)
+	specificYieldMonth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_specific_yield_month",
+			Help: "This Month's energy per installed capacity in kWh/kWp",
+		},
+		[]string{"site"},
+	)
+	specificYieldYear = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_specific_yield_year",
+			Help: "This Year's energy per installed capacity in kWh/kWp",
+		},
+		[]string{"site"},
+	)
+	powerPerKWp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_power_now_per_kwp",
+			Help: "Current power per installed capacity in W/kWp",
+		},
+		[]string{"site"},
+	)
+	expectedEnergyToday = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_expected_energy_today",
+			Help: "Energy expected for today in Wh, from the site metadata and the weather if available",
+		},
+		[]string{"site"},
+	)
+	expectedRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_expected_ratio",
+			Help: "Today's energy as a fraction of the energy expected so far",
+		},
+		[]string{"site"},
+	)
+	underperforming = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_underperforming",
+			Help: "1 when the site stayed below the expected yield threshold for the configured days",
+		},
+		[]string{"site"},
+	)
+	peerAnomalyScore = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_peer_anomaly_score",
+			Help: "The specific yield of the last finished day compared with the other sites of the region, in deviations",
+		},
+		[]string{"site", "region"},
+	)
+	peerAnomaly = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_peer_anomaly",
+			Help: "1 when the site's specific yield is an outlier in its region",
+		},
+		[]string{"site", "region"},
+	)
+	degradationRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_degradation_rate",
+			Help: "Estimated loss of output in percent per year, from the year-over-year daily history",
+		},
+		[]string{"site", "normalization"},
+	)
+	degradationPairs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_degradation_pairs",
+			Help: "Days compared with the same day a year earlier for the degradation estimate",
+		},
+		[]string{"site"},
+	)
+	loginErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "solar_login_errors_total",
+			Help: "Failed logins to the vendor portal",
+		},
+		[]string{"site"},
+	)
+	apiQuotaRemaining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_api_quota_remaining",
+			Help: "API calls left today for the site's API key",
+		},
+		[]string{"site"},
+	)
+	inverterPowerNow = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_inverter_power_now",
+			Help: "Power Now per inverter in W",
+		},
+		[]string{"site", "serial"},
+	)
+	inverterEnergyToday = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_inverter_energy_today",
+			Help: "Today's Energy per inverter in Wh",
+		},
+		[]string{"site", "serial"},
+	)
+	inverterEnergyTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_inverter_energy_total",
+			Help: "Total Energy per inverter in Wh",
+		},
+		[]string{"site", "serial"},
+	)
+	inverterACVoltage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_inverter_ac_voltage",
+			Help: "AC Voltage per inverter in V",
+		},
+		[]string{"site", "serial"},
+	)
+	inverterACCurrent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_inverter_ac_current",
+			Help: "AC Current per inverter in A",
+		},
+		[]string{"site", "serial"},
+	)
+	inverterACFrequency = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_inverter_ac_frequency",
+			Help: "AC Frequency per inverter in Hz",
+		},
+		[]string{"site", "serial"},
+	)
+	inverterDCVoltage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_inverter_dc_voltage",
+			Help: "DC Voltage per inverter in V",
+		},
+		[]string{"site", "serial"},
+	)
+	inverterTemperature = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_inverter_temperature",
+			Help: "Temperature per inverter in degrees Celsius",
+		},
+		[]string{"site", "serial"},
+	)
+	inverterMode = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_inverter_mode",
+			Help: "Operating mode per inverter, 1 for the current mode",
+		},
+		[]string{"site", "serial", "mode"},
+	)
+	inverterWarning = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_inverter_warning",
+			Help: "Active warnings per inverter, 1 for each warning",
+		},
+		[]string{"site", "serial", "warning"},
+	)
+	inverterInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_inverter_info",
+			Help: "Inverter details, always 1",
+		},
+		[]string{"site", "serial", "name", "manufacturer", "model"},
+	)
+	stringPowerNow = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_string_power_now",
+			Help: "DC Power Now per string in W",
+		},
+		[]string{"site", "serial", "string"},
+	)
+	stringEnergyToday = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_string_energy_today",
+			Help: "Today's DC Energy per string in Wh",
+		},
+		[]string{"site", "serial", "string"},
+	)
+	stringEnergyTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_string_energy_total",
+			Help: "Total DC Energy per string in Wh",
+		},
+		[]string{"site", "serial", "string"},
+	)
+	stringVoltage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_string_voltage",
+			Help: "DC Voltage per string in V",
+		},
+		[]string{"site", "serial", "string"},
+	)
+	stringCurrent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "solar_string_current",
+			Help: "DC Current per string in A",
+		},
+		[]string{"site", "serial", "string"},
+	)
+)
+
+// setOptionalGauge sets the gauge only when the provider reported a value.
+func setOptionalGauge(g *prometheus.GaugeVec, v *float64, labels ...string) {
+	if v != nil {
+		g.WithLabelValues(labels...).Set(*v)
+	}
+}
+
+// ledgers holds the tariff ledger of each site with a tariff. It is filled
+// before the metrics collection starts.
+var ledgers = map[string]*tariff.Ledger{}
+
+func recordMoney(site string, status *models.SolarStatus) error {
+	ledger, ok := ledgers[site]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	if err := ledger.Record(status, now); err != nil {
+		return err
+	}
+	saved, err := ledger.Totals(tariff.Saved, now)
+	if err != nil {
+		return err
+	}
+	earned, err := ledger.Totals(tariff.Earned, now)
+	if err != nil {
+		return err
+	}
+	currency := ledger.Currency()
+	savingsToday.WithLabelValues(site, currency).Set(saved.Today)
+	savingsMonth.WithLabelValues(site, currency).Set(saved.Month)
+	savingsYear.WithLabelValues(site, currency).Set(saved.Year)
+	earningsToday.WithLabelValues(site, currency).Set(earned.Today)
+	earningsMonth.WithLabelValues(site, currency).Set(earned.Month)
+	earningsYear.WithLabelValues(site, currency).Set(earned.Year)
+	return nil
+}
+
+// siteMetadata holds the metadata of each site that has it configured. It is
+// filled before the metrics collection starts.
+var siteMetadata = map[string]models.SiteMetadata{}
+
+func recordSpecificYield(p services.SolarStatusProvider, status *models.SolarStatus, month, year float64) error {
+	site := p.Site()
+	m, ok := siteMetadata[site]
+	if !ok {
+		return nil
+	}
+	today := m.SpecificYield(status.EnergyToday)
+	specificYieldToday.WithLabelValues(site).Set(today)
+	specificYieldMonth.WithLabelValues(site).Set(m.SpecificYield(month))
+	specificYieldYear.WithLabelValues(site).Set(m.SpecificYield(year))
+	powerPerKWp.WithLabelValues(site).Set(status.PowerNow / m.KWp)
+	return p.DB().SaveSpecificYield(time.Now().Format("2006-01-02"), today)
+}
+
+// monitors holds the expected yield monitor of each site that has one. It is
+// filled before the metrics collection starts.
+var monitors = map[string]*performance.Monitor{}
+
+func recordExpectedYield(site string, status *models.SolarStatus) error {
+	monitor, ok := monitors[site]
+	if !ok {
+		return nil
+	}
+	result, err := monitor.Record(status, time.Now())
+	if err != nil {
+		return err
+	}
+	expectedEnergyToday.WithLabelValues(site).Set(result.ExpectedToday)
+	expectedRatio.WithLabelValues(site).Set(result.Ratio)
+	flag := 0.0
+	if result.Underperforming {
+		flag = 1
+	}
+	underperforming.WithLabelValues(site).Set(flag)
+	return nil
+}
+
+// regions holds the peer group of each site in a region. It is filled before
+// the metrics collection starts.
+var regions = map[string]*performance.PeerGroup{}
+
+func recordPeers(site string, status *models.SolarStatus) {
+	group, ok := regions[site]
+	if !ok {
+		return
+	}
+	region := group.Name()
+	score, anomaly, ok := group.Record(site, time.Now().Format("2006-01-02"), siteMetadata[site].SpecificYield(status.EnergyToday))
+	if !ok {
+		peerAnomalyScore.DeleteLabelValues(site, region)
+		peerAnomaly.DeleteLabelValues(site, region)
+		return
+	}
+	peerAnomalyScore.WithLabelValues(site, region).Set(score)
+	flag := 0.0
+	if anomaly {
+		flag = 1
+	}
+	peerAnomaly.WithLabelValues(site, region).Set(flag)
+}
+
+// degradation holds the latest degradation estimate of each site with
+// metadata, served by the API.
+var (
+	degradationMu sync.Mutex
+	degradation   = map[string]performance.Degradation{}
 )
 
+// analyzeDegradation estimates the degradation of every site with metadata
+// from its daily history. The index is normalized by the expected yield or
+// the peers of the region when those have enough history, and is the plain
+// specific yield otherwise.
+func analyzeDegradation(providers []services.SolarStatusProvider) {
+	dbs := map[string]*models.DataBase{}
+	for _, p := range providers {
+		dbs[p.Site()] = p.DB()
+	}
+	specific := map[string]performance.Index{}
+	for site, m := range siteMetadata {
+		daily, err := dbs[site].GetDailyValues()
+		if err != nil {
+			log.Printf("%s - Could not read the daily history: %s", site, err)
+			continue
+		}
+		specific[site] = performance.SpecificYieldIndex(daily, m)
+	}
+
+	for site, index := range specific {
+		var candidates []performance.Degradation
+		if _, ok := monitors[site]; ok {
+			yields, err := dbs[site].GetExpectedYieldHistory()
+			if err != nil {
+				log.Printf("%s - Could not read the expected yield history: %s", site, err)
+			} else {
+				candidates = append(candidates, performance.Analyze(site, performance.NormalizedExpected, performance.ExpectedIndex(yields)))
+			}
+		}
+		if group, ok := regions[site]; ok {
+			var peers []performance.Index
+			for peer, g := range regions {
+				if g == group && peer != site {
+					peers = append(peers, specific[peer])
+				}
+			}
+			candidates = append(candidates, performance.Analyze(site, performance.NormalizedPeers, performance.PeerIndex(index, peers)))
+		}
+		candidates = append(candidates, performance.Analyze(site, performance.NormalizedNone, index))
+
+		d := candidates[len(candidates)-1]
+		for _, c := range candidates {
+			if c.Estimated {
+				d = c
+				break
+			}
+		}
+		degradationMu.Lock()
+		degradation[site] = d
+		degradationMu.Unlock()
+
+		degradationRate.DeletePartialMatch(prometheus.Labels{"site": site})
+		degradationPairs.WithLabelValues(site).Set(float64(d.Pairs))
+		if d.Estimated {
+			log.Printf("%s - Estimated degradation %.2f%%/year from %d days (%s)", site, d.RatePerYear, d.Pairs, d.Normalization)
+			degradationRate.WithLabelValues(site, d.Normalization).Set(d.RatePerYear)
+		}
+	}
+}
+
+// degradationHandler serves the degradation estimates as JSON, for all sites
+// or the one given by the site parameter.
+func degradationHandler(w http.ResponseWriter, r *http.Request) {
+	degradationMu.Lock()
+	defer degradationMu.Unlock()
+
+	result := []performance.Degradation{}
+	for site, d := range degradation {
+		if s := r.URL.Query().Get("site"); s == "" || s == site {
+			result = append(result, d)
+		}
+	}
+	if len(result) == 0 && r.URL.Query().Get("site") != "" {
+		http.Error(w, "unknown site", http.StatusNotFound)
+		return
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Site < result[j].Site })
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("Could not write the degradation response: %s", err)
+	}
+}
+
+// alerts evaluates the alert rules, or is nil when none are configured.
+var alerts *alert.Engine
+
+// apiToken is the bearer token that requests changing state through the API
+// must carry. Without a token such requests are refused.
+var apiToken string
+
+// authorized reports whether r carries the API token.
+func authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return apiToken != "" && ok && subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) == 1
+}
+
+// silencesHandler lists the active alert silences, or with POST silences the
+// rule parameter, or all rules without it, of site for duration.
+func silencesHandler(w http.ResponseWriter, r *http.Request) {
+	if alerts == nil {
+		http.Error(w, "no alert rules configured", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodPost {
+		if !authorized(r) {
+			http.Error(w, "a silence needs the api_token of the server as bearer token", http.StatusUnauthorized)
+			return
+		}
+		duration, err := time.ParseDuration(r.FormValue("duration"))
+		if err != nil || duration <= 0 {
+			http.Error(w, "duration must be a positive duration like 2h", http.StatusBadRequest)
+			return
+		}
+		rule := r.FormValue("rule")
+		if rule == "" {
+			rule = alert.AllRules
+		}
+		if err := alerts.Silence(r.FormValue("site"), rule, time.Now().Add(duration)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("%s - Silenced alert rule [%s] for %s", r.FormValue("site"), rule, duration)
+	}
+	silences, err := alerts.Silences()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(silences); err != nil {
+		log.Printf("Could not write the silences response: %s", err)
+	}
+}
+
 func retrieveMetrics(p services.SolarStatusProvider) error {
 	Site := p.Site()
 
-	log.Printf("%s - Start retrieving status from provider %T.\n", Site, p)
-	status, err := p.GetSolarStatus()
-	if err != nil {
-		return err
+	log.Printf("%s - Start retrieving status from provider %T.\n", Site, p)
+	status, err := services.Status(p)
+	if errors.Is(err, services.ErrPollSkipped) {
+		// Not a reading, so nothing is saved, recorded or observed.
+		log.Printf("%s - %s", Site, err)
+		return nil
+	}
+	if alerts != nil {
+		alerts.Observe(Site, status, err)
+	}
+	if q, ok := services.As[services.QuotaProvider](p); ok {
+		if remaining, ok := q.QuotaRemaining(); ok {
+			apiQuotaRemaining.WithLabelValues(Site).Set(float64(remaining))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("%s - Successfully retrieved status from provider %T.\n", Site, p)
+
+	// Meters report no production, which is not the same as producing nothing.
+	production := services.HasProduction(p)
+	if production {
+		powerNow.WithLabelValues(Site).Set(status.PowerNow)
+		energyToday.WithLabelValues(Site).Set(status.EnergyToday)
+		energyTotal.WithLabelValues(Site).Set(status.EnergyTotal)
+	}
+
+	for _, inv := range status.Inverters {
+		setOptionalGauge(inverterPowerNow, inv.PowerNow, Site, inv.Serial)
+		setOptionalGauge(inverterEnergyToday, inv.EnergyToday, Site, inv.Serial)
+		setOptionalGauge(inverterEnergyTotal, inv.EnergyTotal, Site, inv.Serial)
+		setOptionalGauge(inverterACVoltage, inv.ACVoltage, Site, inv.Serial)
+		setOptionalGauge(inverterACCurrent, inv.ACCurrent, Site, inv.Serial)
+		setOptionalGauge(inverterACFrequency, inv.ACFrequency, Site, inv.Serial)
+		setOptionalGauge(inverterDCVoltage, inv.DCVoltage, Site, inv.Serial)
+		setOptionalGauge(inverterTemperature, inv.Temperature, Site, inv.Serial)
+		if inv.Mode != "" {
+			inverterMode.DeletePartialMatch(prometheus.Labels{"site": Site, "serial": inv.Serial})
+			inverterMode.WithLabelValues(Site, inv.Serial, inv.Mode).Set(1)
+		}
+		inverterWarning.DeletePartialMatch(prometheus.Labels{"site": Site, "serial": inv.Serial})
+		for _, w := range inv.Warnings {
+			inverterWarning.WithLabelValues(Site, inv.Serial, w).Set(1)
+		}
+		if inv.Manufacturer != "" || inv.Model != "" {
+			inverterInfo.DeletePartialMatch(prometheus.Labels{"site": Site, "serial": inv.Serial})
+			inverterInfo.WithLabelValues(Site, inv.Serial, inv.Name, inv.Manufacturer, inv.Model).Set(1)
+		}
+		for _, s := range inv.Strings {
+			setOptionalGauge(stringPowerNow, s.PowerNow, Site, inv.Serial, s.Name)
+			setOptionalGauge(stringEnergyToday, s.EnergyToday, Site, inv.Serial, s.Name)
+			setOptionalGauge(stringEnergyTotal, s.EnergyTotal, Site, inv.Serial, s.Name)
+			setOptionalGauge(stringVoltage, s.Voltage, Site, inv.Serial, s.Name)
+			setOptionalGauge(stringCurrent, s.Current, Site, inv.Serial, s.Name)
+		}
+	}
+
+	if grid := status.Grid; grid != nil {
+		gridPowerImport.WithLabelValues(Site).Set(grid.PowerImport)
+		gridPowerExport.WithLabelValues(Site).Set(grid.PowerExport)
+		setOptionalGauge(gridEnergyImport, grid.EnergyImport, Site, "total")
+		setOptionalGauge(gridEnergyExport, grid.EnergyExport, Site, "total")
+		for _, t := range grid.Tariffs {
+			gridEnergyImport.WithLabelValues(Site, t.Tariff).Set(t.EnergyImport)
+			gridEnergyExport.WithLabelValues(Site, t.Tariff).Set(t.EnergyExport)
+		}
+	}
+
+	if load := status.Load; load != nil {
+		loadPowerNow.WithLabelValues(Site).Set(load.PowerNow)
+		setOptionalGauge(loadEnergyTotal, load.EnergyTotal, Site)
+	}
+
+	if battery := status.Battery; battery != nil {
+		batteryPowerCharge.WithLabelValues(Site).Set(battery.PowerCharge)
+		batteryPowerDischarge.WithLabelValues(Site).Set(battery.PowerDischarge)
+		setOptionalGauge(batteryStateOfCharge, battery.StateOfCharge, Site)
+		setOptionalGauge(batteryEnergyCharged, battery.EnergyCharged, Site)
+		setOptionalGauge(batteryEnergyDischarged, battery.EnergyDischarged, Site)
+	}
+
+	if f := status.Financial; f != nil {
+		setOptionalGauge(incomeToday, f.IncomeToday, Site, f.Currency)
+		setOptionalGauge(incomeMonth, f.IncomeMonth, Site, f.Currency)
+		setOptionalGauge(incomeYear, f.IncomeYear, Site, f.Currency)
+		incomeTotal.WithLabelValues(Site, f.Currency).Set(f.IncomeTotal)
+	}
+
+	if e := status.Environmental; e != nil {
+		co2Avoided.WithLabelValues(Site).Set(e.CO2Avoided)
+		setOptionalGauge(treesPlanted, e.TreesPlanted, Site)
 	}
-	log.Printf("%s - Successfully retrieved status from provider %T.\n", Site, p)
 
-	powerNow.WithLabelValues(Site).Set(status.PowerNow)
-	energyToday.WithLabelValues(Site).Set(status.EnergyToday)
-	energyTotal.WithLabelValues(Site).Set(status.EnergyTotal)
+	if !production {
+		return nil
+	}
 
 	log.Printf("%s - Synchronizing values with database.\n", Site)
 	p.DB().SaveTodayValue(status.EnergyToday)
@@ -109,39 +829,108 @@ func retrieveMetrics(p services.SolarStatusProvider) error {
 	dayRecord.DeleteLabelValues(Site)
 	dayRecord.WithLabelValues(Site, record_date).Set(value)
 
+	if err := recordSpecificYield(p, status, monthTotal, yearTotal); err != nil {
+		log.Printf("%s - Could not save the specific yield: %s", Site, err)
+	}
+
+	if err := recordExpectedYield(Site, status); err != nil {
+		log.Printf("%s - Could not compute the expected yield: %s", Site, err)
+	}
+
+	recordPeers(Site, status)
+
+	if err := recordMoney(Site, status); err != nil {
+		log.Printf("%s - Could not apply the tariff: %s", Site, err)
+	}
+
 	log.Printf("%s - Synchronized with database.\n", Site)
 	return nil
 }
 
+// ginlongPlantAttempts is how often listing the Ginlong plants is tried at
+// startup before giving up on it.
+const ginlongPlantAttempts = 3
+
+// ginlongPlants lists the plants of a Ginlong account, retrying when the portal
+// cannot be reached. Rejected credentials are not retried.
+func ginlongPlants(p *services.GinlongProvider) ([]services.GinlongPlant, error) {
+	var err error
+	for attempt := 1; attempt <= ginlongPlantAttempts; attempt++ {
+		var plants []services.GinlongPlant
+		plants, err = p.Plants()
+		var loginErr *services.LoginError
+		if err == nil || errors.As(err, &loginErr) {
+			return plants, err
+		}
+		if attempt < ginlongPlantAttempts {
+			log.Printf("%s - Could not list the Ginlong plants, retrying: %s", p.Site(), err)
+			time.Sleep(10 * time.Second)
+		}
+	}
+	return nil, err
+}
+
+// schedules holds the polling schedule of each site with metadata when a
+// schedule is configured. It is filled before the metrics collection starts.
+var schedules = map[string]*schedule.Schedule{}
+
 func recordMetrics(p services.SolarStatusProvider) {
 	go func() {
 		for {
 			err := retrieveMetrics(p)
-			if err != nil {
+			var loginErr *services.LoginError
+			if errors.As(err, &loginErr) {
+				loginErrors.WithLabelValues(p.Site()).Inc()
+				log.Printf("%s - Login failed, check the credentials: %s", p.Site(), err)
+			} else if err != nil {
 				log.Printf("%s - Could not retrieve metrics: %s", p.Site(), err)
 			}
-			time.Sleep(time.Second * time.Duration(p.Timeout()))
+			wait := time.Second * time.Duration(p.Timeout())
+			if s, ok := schedules[p.Site()]; ok {
+				if next := s.Next(time.Now(), wait); next != wait {
+					log.Printf("%s - Night time, next poll at %s", p.Site(), time.Now().Add(next).Format(time.RFC3339))
+					wait = next
+				}
+			}
+			time.Sleep(wait)
 		}
 	}()
 }
 
+// defaultRecordLimit is the number of recordings kept per site when
+// record_limit is not set.
+const defaultRecordLimit = 1000
+
 type Config struct {
 	Server struct {
 		Port           string `yaml:"port"`
 		DbDir          string `yaml:"db_dir"`
 		DefaultTimeout int    `yaml:"default_timeout"`
+		RecordDir      string `yaml:"record_dir"`
+		// RecordLimit is the number of recordings kept per site.
+		RecordLimit *int `yaml:"record_limit"`
+		// APIToken allows changes through the API, like silencing alerts.
+		APIToken string `yaml:"api_token"`
 	} `yaml:"server"`
 	SolarEdge []struct {
 		Site    string `yaml:"site"`
 		APIKey  string `yaml:"api_key"`
 		Pid     string `yaml:"pid"`
 		Timeout int    `yaml:"timeout"`
+		// Equipment enables per-inverter telemetry, fetched at most once per
+		// EquipmentInterval seconds.
+		Equipment         bool `yaml:"equipment"`
+		EquipmentInterval int  `yaml:"equipment_interval"`
+		// PowerFlow enables the grid, load and battery readings.
+		PowerFlow bool `yaml:"power_flow"`
 	} `yaml:"solaredge"`
 	Sems []struct {
-		Site     string `yaml:"site"`
-		Account  string `yaml:"account"`
-		Password string `yaml:"password"`
-		Timeout  int    `yaml:"timeout"`
+		Site      string `yaml:"site"`
+		Account   string `yaml:"account"`
+		Password  string `yaml:"password"`
+		Region    string `yaml:"region"`
+		StationID string `yaml:"station_id"`
+		Timeout   int    `yaml:"timeout"`
 	} `yaml:"sems"`
 	Ginlong []struct {
 		Site     string `yaml:"site"`
@@ -150,6 +939,63 @@ type Config struct {
 		Password string `yaml:"password"`
 		Timeout  int    `yaml:"timeout"`
 	} `yaml:"ginlong"`
+	FusionSolar []struct {
+		Site        string `yaml:"site"`
+		BaseURL     string `yaml:"base_url"`
+		Username    string `yaml:"username"`
+		SystemCode  string `yaml:"system_code"`
+		StationCode string `yaml:"station_code"`
+		Timeout     int    `yaml:"timeout"`
+	} `yaml:"fusionsolar"`
+	Growatt []struct {
+		Site     string `yaml:"site"`
+		BaseURL  string `yaml:"base_url"`
+		Pid      string `yaml:"pid"`
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+		Timeout  int    `yaml:"timeout"`
+	} `yaml:"growatt"`
+	SMA []struct {
+		Site      string `yaml:"site"`
+		Interface string `yaml:"interface"`
+		GridMeter uint32 `yaml:"grid_meter"`
+		PVMeter   uint32 `yaml:"pv_meter"`
+		Timeout   int    `yaml:"timeout"`
+	} `yaml:"sma"`
+	P1 []struct {
+		Site    string `yaml:"site"`
+		Source  string `yaml:"source"`
+		Timeout int    `yaml:"timeout"`
+	} `yaml:"p1"`
+	OpenDTU []struct {
+		Site     string `yaml:"site"`
+		BaseURL  string `yaml:"base_url"`
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+		Timeout  int    `yaml:"timeout"`
+	} `yaml:"opendtu"`
+	AhoyDTU []struct {
+		Site    string `yaml:"site"`
+		BaseURL string `yaml:"base_url"`
+		Timeout int    `yaml:"timeout"`
+	} `yaml:"ahoydtu"`
+	GenericHTTP   []services.GenericHTTPConfig   `yaml:"generic_http"`
+	MQTT          []services.MQTTConfig          `yaml:"mqtt"`
+	HomeAssistant []services.HomeAssistantConfig `yaml:"homeassistant"`
+	Simulator     []services.SimulatorConfig     `yaml:"simulator"`
+	Replay        []services.ReplayConfig        `yaml:"replay"`
+	Tariffs       []struct {
+		Sites         []string `yaml:"sites"`
+		tariff.Tariff `yaml:",inline"`
+	} `yaml:"tariffs"`
+	Sites         []models.SiteMetadata `yaml:"sites"`
+	ExpectedYield []struct {
+		Sites              []string `yaml:"sites"`
+		performance.Config `yaml:",inline"`
+	} `yaml:"expected_yield"`
+	Regions  []performance.PeerConfig `yaml:"regions"`
+	Alerts   alert.Config             `yaml:"alerts"`
+	Schedule *schedule.Config         `yaml:"schedule"`
 }
 
 func NewConfig(configPath string) (*Config, error) {
@@ -221,8 +1067,63 @@ func main() {
 	prometheus.MustRegister(energyYear)
 	prometheus.MustRegister(energyTotal)
 	prometheus.MustRegister(dayRecord)
+	prometheus.MustRegister(gridPowerImport)
+	prometheus.MustRegister(gridPowerExport)
+	prometheus.MustRegister(gridEnergyImport)
+	prometheus.MustRegister(gridEnergyExport)
+	prometheus.MustRegister(loadPowerNow)
+	prometheus.MustRegister(loadEnergyTotal)
+	prometheus.MustRegister(batteryPowerCharge)
+	prometheus.MustRegister(batteryPowerDischarge)
+	prometheus.MustRegister(batteryStateOfCharge)
+	prometheus.MustRegister(batteryEnergyCharged)
+	prometheus.MustRegister(batteryEnergyDischarged)
+	prometheus.MustRegister(apiQuotaRemaining)
+	prometheus.MustRegister(loginErrors)
+	prometheus.MustRegister(siteCapacity)
+	prometheus.MustRegister(siteInfo)
+	prometheus.MustRegister(specificYieldToday)
+	prometheus.MustRegister(specificYieldMonth)
+	prometheus.MustRegister(specificYieldYear)
+	prometheus.MustRegister(powerPerKWp)
+	prometheus.MustRegister(expectedEnergyToday)
+	prometheus.MustRegister(expectedRatio)
+	prometheus.MustRegister(underperforming)
+	prometheus.MustRegister(peerAnomalyScore)
+	prometheus.MustRegister(peerAnomaly)
+	prometheus.MustRegister(degradationRate)
+	prometheus.MustRegister(degradationPairs)
+	prometheus.MustRegister(savingsToday)
+	prometheus.MustRegister(savingsMonth)
+	prometheus.MustRegister(savingsYear)
+	prometheus.MustRegister(earningsToday)
+	prometheus.MustRegister(earningsMonth)
+	prometheus.MustRegister(earningsYear)
+	prometheus.MustRegister(incomeToday)
+	prometheus.MustRegister(incomeMonth)
+	prometheus.MustRegister(incomeYear)
+	prometheus.MustRegister(incomeTotal)
+	prometheus.MustRegister(co2Avoided)
+	prometheus.MustRegister(treesPlanted)
+	prometheus.MustRegister(inverterPowerNow)
+	prometheus.MustRegister(inverterEnergyToday)
+	prometheus.MustRegister(inverterEnergyTotal)
+	prometheus.MustRegister(inverterACVoltage)
+	prometheus.MustRegister(inverterACCurrent)
+	prometheus.MustRegister(inverterACFrequency)
+	prometheus.MustRegister(inverterDCVoltage)
+	prometheus.MustRegister(inverterTemperature)
+	prometheus.MustRegister(inverterMode)
+	prometheus.MustRegister(inverterWarning)
+	prometheus.MustRegister(inverterInfo)
+	prometheus.MustRegister(stringPowerNow)
+	prometheus.MustRegister(stringEnergyToday)
+	prometheus.MustRegister(stringEnergyTotal)
+	prometheus.MustRegister(stringVoltage)
+	prometheus.MustRegister(stringCurrent)
 
 	databaseDir := cfg.Server.DbDir
+	apiToken = cfg.Server.APIToken
 
 	_, err = os.Stat(databaseDir)
 	if os.IsNotExist(err) {
@@ -233,6 +1134,55 @@ func main() {
 
 	// Load all providers
 	for _, p := range cfg.Ginlong {
+		timeout := p.Timeout
+		if timeout == 0 {
+			timeout = cfg.Server.DefaultTimeout
+		}
+		// Check the configured plant against the account, or create a site for
+		// every plant when none is configured.
+		plants, err := ginlongPlants(services.NewGinlongProvider(p.Site, p.Username, p.Password, "", timeout, nil))
+		sites := map[string]string{}
+		switch {
+		case p.Pid != "" && err != nil:
+			log.Printf("%s - Could not list the Ginlong plants, not checking plant [%s]: %s", p.Site, p.Pid, err)
+			sites[p.Site] = p.Pid
+		case p.Pid != "":
+			found := false
+			for _, plant := range plants {
+				found = found || plant.ID == p.Pid
+			}
+			if !found {
+				log.Fatalf("%s - Ginlong plant [%s] not found for user [%s], available plants: %v", p.Site, p.Pid, p.Username, plants)
+			}
+			sites[p.Site] = p.Pid
+		case err != nil:
+			// The sites follow from the plants, so there is nothing to poll
+			// without them.
+			log.Fatalf("%s - Could not list the Ginlong plants, set a pid to start without the portal: %s", p.Site, err)
+		case len(plants) == 0:
+			log.Fatalf("%s - No Ginlong plants found for user [%s]", p.Site, p.Username)
+		default:
+			// The plant id keeps the name stable when plants are added later.
+			for _, plant := range plants {
+				site := fmt.Sprintf("%s-%s", p.Site, plant.ID)
+				log.Printf("%s - Found Ginlong plant [%s] (%s)", site, plant.ID, plant.Name)
+				sites[site] = plant.ID
+			}
+		}
+		for site, pid := range sites {
+			databaseFile := fmt.Sprintf("%s/%s.db", databaseDir, site)
+			db, err := models.NewDB(databaseFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			provider := services.NewGinlongProvider(site, p.Username, p.Password, pid, timeout, db)
+			providers = append(providers, provider)
+		}
+	}
+
+	// Sites sharing an API key share its daily budget.
+	quotas := map[string]*services.SolarEdgeQuota{}
+	for _, p := range cfg.SolarEdge {
 		timeout := p.Timeout
 		if timeout == 0 {
 			timeout = cfg.Server.DefaultTimeout
@@ -242,14 +1192,23 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
-		if p.Pid == "" {
-			p.Pid = "172533"
+		provider := services.NewSolarEdgeProvider(p.Site, p.APIKey, p.Pid, timeout, db)
+		quota, ok := quotas[p.APIKey]
+		if !ok {
+			quota = services.NewSolarEdgeQuota(services.SolarEdgeQuotaPath(databaseDir, p.APIKey), services.SolarEdgeDailyLimit)
+			quotas[p.APIKey] = quota
+		}
+		provider.SetQuota(quota)
+		if p.PowerFlow {
+			provider.EnablePowerFlow()
+		}
+		if p.Equipment {
+			provider.EnableEquipment(p.EquipmentInterval)
 		}
-		provider := services.NewGinlongProvider(p.Site, p.Username, p.Password, p.Pid, timeout, db)
 		providers = append(providers, provider)
 	}
 
-	for _, p := range cfg.SolarEdge {
+	for _, p := range cfg.Sems {
 		timeout := p.Timeout
 		if timeout == 0 {
 			timeout = cfg.Server.DefaultTimeout
@@ -259,33 +1218,359 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
-		provider := services.NewSolarEdgeProvider(p.Site, p.APIKey, p.Pid, timeout, db)
+		provider, err := services.NewSemsProvider(p.Site, p.Account, p.Password, p.Region, p.StationID, timeout, db)
+		if err != nil {
+			log.Fatal(err)
+		}
 		providers = append(providers, provider)
 	}
 
-	for _, p := range cfg.Sems {
+	for _, p := range cfg.FusionSolar {
+		timeout := p.Timeout
+		if timeout == 0 {
+			timeout = cfg.Server.DefaultTimeout
+		}
+		databaseFile := fmt.Sprintf("%s/%s.db", databaseDir, p.Site)
+		db, err := models.NewDB(databaseFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		provider := services.NewFusionSolarProvider(p.Site, p.BaseURL, p.Username, p.SystemCode, p.StationCode, timeout, db)
+		providers = append(providers, provider)
+	}
+
+	for _, p := range cfg.Growatt {
+		timeout := p.Timeout
+		if timeout == 0 {
+			timeout = cfg.Server.DefaultTimeout
+		}
+		databaseFile := fmt.Sprintf("%s/%s.db", databaseDir, p.Site)
+		db, err := models.NewDB(databaseFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		provider := services.NewGrowattProvider(p.Site, p.BaseURL, p.Username, p.Password, p.Pid, timeout, db)
+		providers = append(providers, provider)
+	}
+
+	for _, p := range cfg.SMA {
+		timeout := p.Timeout
+		if timeout == 0 {
+			timeout = cfg.Server.DefaultTimeout
+		}
+		databaseFile := fmt.Sprintf("%s/%s.db", databaseDir, p.Site)
+		db, err := models.NewDB(databaseFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		provider := services.NewSMAProvider(p.Site, p.Interface, p.GridMeter, p.PVMeter, timeout, db)
+		if err := provider.Listen(); err != nil {
+			log.Fatal(err)
+		}
+		providers = append(providers, provider)
+	}
+
+	for _, p := range cfg.OpenDTU {
+		timeout := p.Timeout
+		if timeout == 0 {
+			timeout = cfg.Server.DefaultTimeout
+		}
+		databaseFile := fmt.Sprintf("%s/%s.db", databaseDir, p.Site)
+		db, err := models.NewDB(databaseFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		provider := services.NewOpenDTUProvider(p.Site, p.BaseURL, p.Username, p.Password, timeout, db)
+		providers = append(providers, provider)
+	}
+
+	for _, p := range cfg.AhoyDTU {
+		timeout := p.Timeout
+		if timeout == 0 {
+			timeout = cfg.Server.DefaultTimeout
+		}
+		databaseFile := fmt.Sprintf("%s/%s.db", databaseDir, p.Site)
+		db, err := models.NewDB(databaseFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		provider := services.NewAhoyDTUProvider(p.Site, p.BaseURL, timeout, db)
+		providers = append(providers, provider)
+	}
+
+	for _, p := range cfg.GenericHTTP {
+		timeout := p.Timeout
+		if timeout == 0 {
+			timeout = cfg.Server.DefaultTimeout
+		}
+		databaseFile := fmt.Sprintf("%s/%s.db", databaseDir, p.Site)
+		db, err := models.NewDB(databaseFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		provider, err := services.NewGenericHTTPProvider(p.Site, p, timeout, db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		providers = append(providers, provider)
+	}
+
+	for _, p := range cfg.MQTT {
+		timeout := p.Timeout
+		if timeout == 0 {
+			timeout = cfg.Server.DefaultTimeout
+		}
+		databaseFile := fmt.Sprintf("%s/%s.db", databaseDir, p.Site)
+		db, err := models.NewDB(databaseFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		provider, err := services.NewMQTTProvider(p.Site, p, timeout, db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		provider.Connect()
+		providers = append(providers, provider)
+	}
+
+	for _, p := range cfg.HomeAssistant {
+		timeout := p.Timeout
+		if timeout == 0 {
+			timeout = cfg.Server.DefaultTimeout
+		}
+		databaseFile := fmt.Sprintf("%s/%s.db", databaseDir, p.Site)
+		db, err := models.NewDB(databaseFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		provider, err := services.NewHomeAssistantProvider(p.Site, p, timeout, db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		providers = append(providers, provider)
+	}
+
+	for _, p := range cfg.Simulator {
+		timeout := p.Timeout
+		if timeout == 0 {
+			timeout = cfg.Server.DefaultTimeout
+		}
+		databaseFile := fmt.Sprintf("%s/%s.db", databaseDir, p.Site)
+		db, err := models.NewDB(databaseFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		provider, err := services.NewSimulatorProvider(p.Site, p, timeout, db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		providers = append(providers, provider)
+	}
+
+	for _, p := range cfg.Replay {
+		timeout := p.Timeout
+		if timeout == 0 {
+			timeout = cfg.Server.DefaultTimeout
+		}
+		databaseFile := fmt.Sprintf("%s/%s.db", databaseDir, p.Site)
+		db, err := models.NewDB(databaseFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		provider, err := services.NewReplayProvider(p, timeout, db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		providers = append(providers, provider)
+	}
+
+	// Record the HTTP exchanges of every provider, for reproducible captures.
+	if cfg.Server.RecordDir != "" {
+		limit := defaultRecordLimit
+		if cfg.Server.RecordLimit != nil {
+			limit = *cfg.Server.RecordLimit
+		}
+		for _, p := range providers {
+			h, ok := services.As[services.HTTPProvider](p)
+			if !ok {
+				continue
+			}
+			recorder, err := services.NewRecordingTransport(filepath.Join(cfg.Server.RecordDir, p.Site()), limit, h.Transport())
+			if err != nil {
+				log.Fatal(err)
+			}
+			h.SetTransport(recorder)
+			log.Printf("%s - Recording HTTP exchanges to [%s]", p.Site(), filepath.Join(cfg.Server.RecordDir, p.Site()))
+		}
+	}
+
+	// P1 meters are attached to the solar site of the same name, or become a
+	// site of their own.
+	for _, p := range cfg.P1 {
 		timeout := p.Timeout
 		if timeout == 0 {
 			timeout = cfg.Server.DefaultTimeout
 		}
+		attached := false
+		for i, existing := range providers {
+			if existing.Site() == p.Site {
+				meter := services.NewP1Provider(p.Site, p.Source, timeout, existing.DB())
+				meter.Listen()
+				providers[i] = services.WithGrid(existing, meter)
+				attached = true
+			}
+		}
+		if attached {
+			continue
+		}
 		databaseFile := fmt.Sprintf("%s/%s.db", databaseDir, p.Site)
 		db, err := models.NewDB(databaseFile)
 		if err != nil {
 			log.Fatal(err)
 		}
-		provider := services.NewSemsProvider(p.Site, p.Account, p.Password, timeout, db)
+		provider := services.NewP1Provider(p.Site, p.Source, timeout, db)
+		provider.Listen()
 		providers = append(providers, provider)
 	}
 
+	for _, t := range cfg.Tariffs {
+		if err := t.Validate(); err != nil {
+			log.Fatalf("Invalid tariff for sites %v: %s", t.Sites, err)
+		}
+		for _, site := range t.Sites {
+			found := false
+			for _, p := range providers {
+				if p.Site() == site {
+					ledgers[site] = tariff.NewLedger(&t.Tariff, p.DB())
+					found = true
+				}
+			}
+			if !found {
+				log.Fatalf("%s - Tariff configured for an unknown site", site)
+			}
+		}
+	}
+
+	for _, m := range cfg.Sites {
+		if err := m.Validate(); err != nil {
+			log.Fatalf("Invalid site metadata: %s", err)
+		}
+		found := false
+		for _, p := range providers {
+			if p.Site() != m.Site {
+				continue
+			}
+			if err := p.DB().SaveMetadata(m); err != nil {
+				log.Fatalf("%s - Could not save the site metadata: %s", m.Site, err)
+			}
+			found = true
+		}
+		if !found {
+			log.Fatalf("%s - Metadata configured for an unknown site", m.Site)
+		}
+		siteMetadata[m.Site] = m
+		lat, lon, located := m.Location()
+		for _, p := range cfg.SolarEdge {
+			if p.Site == m.Site && located {
+				quotas[p.APIKey].SetLocation(m.Site, lat, lon)
+			}
+		}
+		latLabel, lonLabel := "", ""
+		if located {
+			latLabel, lonLabel = strconv.FormatFloat(lat, 'f', -1, 64), strconv.FormatFloat(lon, 'f', -1, 64)
+		}
+		siteCapacity.WithLabelValues(m.Site).Set(m.KWp)
+		siteInfo.WithLabelValues(m.Site, strconv.FormatFloat(m.Tilt, 'f', -1, 64), strconv.FormatFloat(m.Azimuth, 'f', -1, 64),
+			latLabel, lonLabel, m.Commissioned).Set(1)
+	}
+
+	for _, e := range cfg.ExpectedYield {
+		if err := e.Validate(); err != nil {
+			log.Fatalf("Invalid expected yield for sites %v: %s", e.Sites, err)
+		}
+		for _, site := range e.Sites {
+			m, ok := siteMetadata[site]
+			if _, _, located := m.Location(); !ok || !located {
+				log.Fatalf("%s - Expected yield needs the site metadata with lat and lon under sites", site)
+			}
+			for _, p := range providers {
+				if p.Site() == site {
+					monitors[site] = performance.NewMonitor(m, e.Config, p.DB())
+				}
+			}
+		}
+	}
+
+	for _, r := range cfg.Regions {
+		if err := r.Validate(); err != nil {
+			log.Fatal(err)
+		}
+		group := performance.NewPeerGroup(r)
+		for _, site := range r.Sites {
+			if _, ok := siteMetadata[site]; !ok {
+				log.Fatalf("%s - Region [%s] needs the site metadata under sites", site, r.Name)
+			}
+			if _, ok := regions[site]; ok {
+				log.Fatalf("%s - Site is in more than one region", site)
+			}
+			regions[site] = group
+		}
+	}
+
+	if len(cfg.Alerts.Rules) > 0 {
+		alerts, err = alert.NewEngine(cfg.Alerts)
+		if err != nil {
+			log.Fatalf("Invalid alerts: %s", err)
+		}
+		for _, p := range providers {
+			var metadata *models.SiteMetadata
+			if m, ok := siteMetadata[p.Site()]; ok {
+				metadata = &m
+			}
+			alerts.AddSite(p.Site(), p.DB(), metadata)
+		}
+		if err := alerts.Check(); err != nil {
+			log.Fatalf("Invalid alerts: %s", err)
+		}
+		// Rules like no data have to fire without a poll.
+		go func() {
+			for range time.Tick(time.Minute) {
+				alerts.Evaluate()
+			}
+		}()
+	}
+
+	if cfg.Schedule != nil {
+		for site, m := range siteMetadata {
+			lat, lon, ok := m.Location()
+			if !ok {
+				log.Fatalf("%s - The schedule needs the lat and lon of the site under sites", site)
+			}
+			schedules[site] = schedule.New(*cfg.Schedule, lat, lon)
+		}
+	}
+
 	// Start Metrics Collection
 	for _, p := range providers {
 		recordMetrics(p)
 	}
 
+	// The daily history only grows by a day, so a daily analysis is plenty.
+	go func() {
+		for {
+			analyzeDegradation(providers)
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+
 	// Start server
+	mux := http.NewServeMux()
+	mux.Handle("/", promhttp.Handler())
+	mux.HandleFunc("/api/degradation", degradationHandler)
+	mux.HandleFunc("/api/silences", silencesHandler)
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%s", cfg.Server.Port),
-		Handler: promhttp.Handler(),
+		Handler: mux,
 	}
 
 	go func() {
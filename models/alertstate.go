@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// AlertState is the state of an alert rule for a site, kept so a restart
+// neither repeats nor forgets notifications. Notified is zero when no
+// notification was sent for the current state.
+type AlertState struct {
+	Rule     string
+	Firing   bool
+	Since    time.Time
+	Notified time.Time
+}
@@ -0,0 +1,12 @@
+package models
+
+// BatteryStatus holds the readings of the batteries of a site. Powers are in W,
+// counters in Wh and the state of charge in percent. Readings the provider
+// does not report are nil.
+type BatteryStatus struct {
+	PowerCharge      float64
+	PowerDischarge   float64
+	StateOfCharge    *float64
+	EnergyCharged    *float64
+	EnergyDischarged *float64
+}
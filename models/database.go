@@ -36,6 +36,19 @@ func NewDB(dbPath string) (*DataBase, error) {
 	if err != nil {
 		return nil, err
 	}
+	for _, table := range []string{
+		"CREATE TABLE IF NOT EXISTS money (date TEXT, kind TEXT, value REAL, PRIMARY KEY (date, kind));",
+		"CREATE TABLE IF NOT EXISTS counters (name TEXT PRIMARY KEY, date TEXT, value REAL);",
+		"CREATE TABLE IF NOT EXISTS metadata (id INTEGER PRIMARY KEY CHECK (id = 1), kwp REAL, tilt REAL, azimuth REAL, lat REAL, lon REAL, commissioned TEXT);",
+		"CREATE TABLE IF NOT EXISTS specific_yield (date TEXT PRIMARY KEY, value REAL);",
+		"CREATE TABLE IF NOT EXISTS expected_yield (date TEXT PRIMARY KEY, expected REAL, actual REAL);",
+		"CREATE TABLE IF NOT EXISTS alerts (rule TEXT PRIMARY KEY, firing INTEGER, since TEXT, notified TEXT);",
+		"CREATE TABLE IF NOT EXISTS silences (rule TEXT PRIMARY KEY, until TEXT);",
+	} {
+		if _, err = tx.Exec(table); err != nil {
+			return nil, err
+		}
+	}
 
 	err = tx.Commit()
 	if err != nil {
@@ -105,6 +118,26 @@ func (d *DataBase) SaveDailyValue(day string, value float64) error {
 	return tx.Commit()
 }
 
+// GetDailyValues returns the energy of every day in the database.
+func (d *DataBase) GetDailyValues() (map[string]float64, error) {
+	rows, err := d.DB.Query("SELECT date, value FROM daily;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := map[string]float64{}
+	for rows.Next() {
+		var day string
+		var value float64
+		if err := rows.Scan(&day, &value); err != nil {
+			return nil, err
+		}
+		values[day] = value
+	}
+	return values, rows.Err()
+}
+
 func (d *DataBase) GetDayRecord() (string, float64, error) {
 	if d.getStmt == nil {
 		var err error
@@ -166,3 +199,180 @@ func (d *DataBase) GetYearTotal() (float64, error) {
 
 	return value, nil
 }
+
+// AddMoney adds value to the amount of the given kind, such as saved or
+// earned, for day.
+func (d *DataBase) AddMoney(day, kind string, value float64) error {
+	_, err := d.DB.Exec("INSERT INTO money (date, kind, value) VALUES (?,?,?) ON CONFLICT(date, kind) DO UPDATE SET value=value+excluded.value;", day, kind, value)
+	return err
+}
+
+// GetMoneyTotal returns the sum of the amounts of the given kind for the days
+// starting with prefix, like "2024-06" for a month.
+func (d *DataBase) GetMoneyTotal(kind, prefix string) (float64, error) {
+	row := d.DB.QueryRow("SELECT COALESCE(SUM(value), 0) FROM money WHERE kind = ? AND date LIKE ?;", kind, fmt.Sprintf("%s%%", prefix))
+	var value float64
+	err := row.Scan(&value)
+	return value, err
+}
+
+// SaveCounter stores the last seen value of a counter, so deltas can be taken
+// across restarts.
+func (d *DataBase) SaveCounter(name, day string, value float64) error {
+	_, err := d.DB.Exec("INSERT INTO counters (name, date, value) VALUES (?,?,?) ON CONFLICT(name) DO UPDATE SET date=excluded.date, value=excluded.value;", name, day, value)
+	return err
+}
+
+// GetCounter returns the last saved value of a counter and the day it was
+// saved, or false when it was never saved.
+func (d *DataBase) GetCounter(name string) (string, float64, bool, error) {
+	row := d.DB.QueryRow("SELECT date, value FROM counters WHERE name = ?;", name)
+	var day string
+	var value float64
+	err := row.Scan(&day, &value)
+	if err == sql.ErrNoRows {
+		return "", 0, false, nil
+	} else if err != nil {
+		return "", 0, false, err
+	}
+	return day, value, true, nil
+}
+
+// SaveMetadata stores the metadata of the site, replacing earlier metadata.
+func (d *DataBase) SaveMetadata(m SiteMetadata) error {
+	_, err := d.DB.Exec("INSERT INTO metadata (id, kwp, tilt, azimuth, lat, lon, commissioned) VALUES (1,?,?,?,?,?,?) ON CONFLICT(id) DO UPDATE SET kwp=excluded.kwp, tilt=excluded.tilt, azimuth=excluded.azimuth, lat=excluded.lat, lon=excluded.lon, commissioned=excluded.commissioned;", m.KWp, m.Tilt, m.Azimuth, m.Lat, m.Lon, m.Commissioned)
+	return err
+}
+
+// GetMetadata returns the stored metadata of the site, or false when none was
+// saved.
+func (d *DataBase) GetMetadata() (SiteMetadata, bool, error) {
+	m := SiteMetadata{}
+	row := d.DB.QueryRow("SELECT kwp, tilt, azimuth, lat, lon, commissioned FROM metadata WHERE id = 1;")
+	err := row.Scan(&m.KWp, &m.Tilt, &m.Azimuth, &m.Lat, &m.Lon, &m.Commissioned)
+	if err == sql.ErrNoRows {
+		return m, false, nil
+	} else if err != nil {
+		return m, false, err
+	}
+	return m, true, nil
+}
+
+// SaveSpecificYield stores the specific yield of day in kWh/kWp.
+func (d *DataBase) SaveSpecificYield(day string, value float64) error {
+	_, err := d.DB.Exec("INSERT INTO specific_yield (date, value) VALUES (?,?) ON CONFLICT(date) DO UPDATE SET value=excluded.value;", day, value)
+	return err
+}
+
+// GetSpecificYield returns the specific yield of day in kWh/kWp.
+func (d *DataBase) GetSpecificYield(day string) (float64, error) {
+	row := d.DB.QueryRow("SELECT value FROM specific_yield WHERE date = ?;", day)
+	var value float64
+	err := row.Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return value, err
+}
+
+// SaveExpectedYield stores the expected and actual energy of a day.
+func (d *DataBase) SaveExpectedYield(y ExpectedYield) error {
+	_, err := d.DB.Exec("INSERT INTO expected_yield (date, expected, actual) VALUES (?,?,?) ON CONFLICT(date) DO UPDATE SET expected=excluded.expected, actual=excluded.actual;", y.Date, y.Expected, y.Actual)
+	return err
+}
+
+// GetExpectedYields returns up to limit days of expected yield before the
+// given day, most recent first.
+func (d *DataBase) GetExpectedYields(before string, limit int) ([]ExpectedYield, error) {
+	rows, err := d.DB.Query("SELECT date, expected, actual FROM expected_yield WHERE date < ? ORDER BY date DESC LIMIT ?;", before, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanExpectedYields(rows)
+}
+
+// GetExpectedYieldHistory returns the expected yield of every day, oldest
+// first.
+func (d *DataBase) GetExpectedYieldHistory() ([]ExpectedYield, error) {
+	rows, err := d.DB.Query("SELECT date, expected, actual FROM expected_yield ORDER BY date;")
+	if err != nil {
+		return nil, err
+	}
+	return scanExpectedYields(rows)
+}
+
+func scanExpectedYields(rows *sql.Rows) ([]ExpectedYield, error) {
+	defer rows.Close()
+
+	var yields []ExpectedYield
+	for rows.Next() {
+		var y ExpectedYield
+		if err := rows.Scan(&y.Date, &y.Expected, &y.Actual); err != nil {
+			return nil, err
+		}
+		yields = append(yields, y)
+	}
+	return yields, rows.Err()
+}
+
+// SaveAlertState stores the state of an alert rule.
+func (d *DataBase) SaveAlertState(a AlertState) error {
+	notified := ""
+	if !a.Notified.IsZero() {
+		notified = a.Notified.Format(time.RFC3339)
+	}
+	_, err := d.DB.Exec("INSERT INTO alerts (rule, firing, since, notified) VALUES (?,?,?,?) ON CONFLICT(rule) DO UPDATE SET firing=excluded.firing, since=excluded.since, notified=excluded.notified;", a.Rule, a.Firing, a.Since.Format(time.RFC3339), notified)
+	return err
+}
+
+// GetAlertState returns the stored state of an alert rule, or false when the
+// rule has none.
+func (d *DataBase) GetAlertState(rule string) (AlertState, bool, error) {
+	a := AlertState{Rule: rule}
+	var since, notified string
+	row := d.DB.QueryRow("SELECT firing, since, notified FROM alerts WHERE rule = ?;", rule)
+	err := row.Scan(&a.Firing, &since, &notified)
+	if err == sql.ErrNoRows {
+		return a, false, nil
+	} else if err != nil {
+		return a, false, err
+	}
+	if a.Since, err = time.Parse(time.RFC3339, since); err != nil {
+		return a, false, err
+	}
+	if notified != "" {
+		if a.Notified, err = time.Parse(time.RFC3339, notified); err != nil {
+			return a, false, err
+		}
+	}
+	return a, true, nil
+}
+
+// SaveSilence silences an alert rule until the given time. The rule "*"
+// silences all rules.
+func (d *DataBase) SaveSilence(rule string, until time.Time) error {
+	_, err := d.DB.Exec("INSERT INTO silences (rule, until) VALUES (?,?) ON CONFLICT(rule) DO UPDATE SET until=excluded.until;", rule, until.Format(time.RFC3339))
+	return err
+}
+
+// GetSilences returns when the silenced rules are silenced until, expired
+// silences included.
+func (d *DataBase) GetSilences() (map[string]time.Time, error) {
+	rows, err := d.DB.Query("SELECT rule, until FROM silences;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	silences := map[string]time.Time{}
+	for rows.Next() {
+		var rule, until string
+		if err := rows.Scan(&rule, &until); err != nil {
+			return nil, err
+		}
+		if silences[rule], err = time.Parse(time.RFC3339, until); err != nil {
+			return nil, err
+		}
+	}
+	return silences, rows.Err()
+}
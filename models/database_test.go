@@ -3,6 +3,7 @@ package models
 import (
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -164,3 +165,182 @@ func TestSaveTodayValue(t *testing.T) {
 		t.Fatalf("Retrieved value does not match expected value. Expected: %f, Got: %f", value2, retrievedValue)
 	}
 }
+
+func TestMoney(t *testing.T) {
+	db, cleanup := prepareDB(t)
+	defer cleanup()
+
+	for _, m := range []struct {
+		day   string
+		value float64
+	}{{"2024-05-31", 1.5}, {"2024-06-01", 2}, {"2024-06-01", 0.25}, {"2024-06-02", 1}} {
+		if err := db.AddMoney(m.day, "saved", m.value); err != nil {
+			t.Fatalf("Error adding money: %v", err)
+		}
+	}
+	if err := db.AddMoney("2024-06-01", "earned", 9); err != nil {
+		t.Fatalf("Error adding money: %v", err)
+	}
+
+	day, _ := db.GetMoneyTotal("saved", "2024-06-01")
+	month, _ := db.GetMoneyTotal("saved", "2024-06")
+	year, _ := db.GetMoneyTotal("saved", "2024")
+	if day != 2.25 || month != 3.25 || year != 4.75 {
+		t.Errorf("Expected 2.25, 3.25 and 4.75, got %f, %f and %f", day, month, year)
+	}
+}
+
+func TestCounter(t *testing.T) {
+	db, cleanup := prepareDB(t)
+	defer cleanup()
+
+	if _, _, ok, err := db.GetCounter("export"); ok || err != nil {
+		t.Fatalf("Expected no counter, got %v %v", ok, err)
+	}
+	db.SaveCounter("export", "2024-06-01", 100)
+	db.SaveCounter("export", "2024-06-02", 150)
+	day, value, ok, err := db.GetCounter("export")
+	if err != nil || !ok || day != "2024-06-02" || value != 150 {
+		t.Errorf("Unexpected counter %s %f %v %v", day, value, ok, err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	db, cleanup := prepareDB(t)
+	defer cleanup()
+
+	if _, ok, err := db.GetMetadata(); ok || err != nil {
+		t.Fatalf("Expected no metadata, got %v %v", ok, err)
+	}
+	lat, lon := 52.1, 5.1
+	m := SiteMetadata{KWp: 4.2, Tilt: 35, Azimuth: 180, Lat: &lat, Lon: &lon, Commissioned: "2020-04-01"}
+	if err := db.SaveMetadata(m); err != nil {
+		t.Fatalf("Error saving metadata: %v", err)
+	}
+	m.KWp = 5.6
+	db.SaveMetadata(m)
+	got, ok, err := db.GetMetadata()
+	if err != nil || !ok || !reflect.DeepEqual(got, m) {
+		t.Errorf("Expected %+v, got %+v %v %v", m, got, ok, err)
+	}
+
+	// A site without a location keeps it unset.
+	db.SaveMetadata(SiteMetadata{KWp: 3})
+	if got, _, _ := db.GetMetadata(); got.Lat != nil || got.Lon != nil {
+		t.Errorf("Expected no location, got %+v", got)
+	}
+
+	db.SaveSpecificYield("2024-06-01", 4.5)
+	if value, _ := db.GetSpecificYield("2024-06-01"); value != 4.5 {
+		t.Errorf("Expected 4.5, got %f", value)
+	}
+	if m.SpecificYield(11200) != 2 {
+		t.Errorf("Expected 2 kWh/kWp, got %f", m.SpecificYield(11200))
+	}
+}
+
+func TestExpectedYield(t *testing.T) {
+	db, cleanup := prepareDB(t)
+	defer cleanup()
+
+	for _, y := range []ExpectedYield{
+		{Date: "2024-06-01", Expected: 20000, Actual: 18000},
+		{Date: "2024-06-02", Expected: 20000, Actual: 10000},
+		{Date: "2024-06-03", Expected: 0, Actual: 0},
+	} {
+		if err := db.SaveExpectedYield(y); err != nil {
+			t.Fatalf("Error saving expected yield: %v", err)
+		}
+	}
+	db.SaveExpectedYield(ExpectedYield{Date: "2024-06-02", Expected: 20000, Actual: 12000})
+
+	yields, err := db.GetExpectedYields("2024-06-03", 5)
+	if err != nil {
+		t.Fatalf("Error getting expected yields: %v", err)
+	}
+	if len(yields) != 2 || yields[0].Date != "2024-06-02" || yields[1].Date != "2024-06-01" {
+		t.Fatalf("Expected the two days before 2024-06-03, most recent first, got %+v", yields)
+	}
+	if ratio, ok := yields[0].Ratio(); !ok || ratio != 0.6 {
+		t.Errorf("Expected ratio 0.6, got %f %v", ratio, ok)
+	}
+	if _, ok := (ExpectedYield{}).Ratio(); ok {
+		t.Errorf("Expected no ratio without expected energy")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	db, cleanup := prepareDB(t)
+	defer cleanup()
+
+	db.SaveDailyValue("2024-06-01", 1000)
+	db.SaveDailyValue("2024-06-02", 2000)
+	values, err := db.GetDailyValues()
+	if err != nil || len(values) != 2 || values["2024-06-02"] != 2000 {
+		t.Errorf("Expected two daily values, got %v %v", values, err)
+	}
+
+	db.SaveExpectedYield(ExpectedYield{Date: "2024-06-02", Expected: 2, Actual: 1})
+	db.SaveExpectedYield(ExpectedYield{Date: "2024-06-01", Expected: 2, Actual: 2})
+	yields, err := db.GetExpectedYieldHistory()
+	if err != nil || len(yields) != 2 || yields[0].Date != "2024-06-01" {
+		t.Errorf("Expected two days of expected yield, oldest first, got %+v %v", yields, err)
+	}
+}
+
+func TestAlertState(t *testing.T) {
+	db, cleanup := prepareDB(t)
+	defer cleanup()
+
+	if _, ok, err := db.GetAlertState("dead"); ok || err != nil {
+		t.Fatalf("Expected no alert state, got %v %v", ok, err)
+	}
+	since := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	a := AlertState{Rule: "dead", Firing: true, Since: since}
+	if err := db.SaveAlertState(a); err != nil {
+		t.Fatalf("Error saving alert state: %v", err)
+	}
+	got, ok, err := db.GetAlertState("dead")
+	if err != nil || !ok || !got.Firing || !got.Since.Equal(since) || !got.Notified.IsZero() {
+		t.Errorf("Expected %+v, got %+v %v %v", a, got, ok, err)
+	}
+	a.Notified = since.Add(time.Minute)
+	db.SaveAlertState(a)
+	if got, _, _ := db.GetAlertState("dead"); !got.Notified.Equal(a.Notified) {
+		t.Errorf("Expected notified at %s, got %s", a.Notified, got.Notified)
+	}
+
+	db.SaveSilence("dead", since.Add(time.Hour))
+	db.SaveSilence("*", since)
+	silences, err := db.GetSilences()
+	if err != nil || len(silences) != 2 || !silences["dead"].Equal(since.Add(time.Hour)) {
+		t.Errorf("Expected two silences, got %v %v", silences, err)
+	}
+}
+
+func TestSiteMetadataValidate(t *testing.T) {
+	lat, lon, far := 52.1, 5.1, 200.0
+	valid := []SiteMetadata{
+		{Site: "a", KWp: 4},
+		{Site: "a", KWp: 4, Lat: &lat, Lon: &lon},
+	}
+	for _, m := range valid {
+		if err := m.Validate(); err != nil {
+			t.Errorf("Unexpected error for %+v: %v", m, err)
+		}
+	}
+	invalid := []SiteMetadata{
+		{Site: "a"},
+		{Site: "a", KWp: 4, Lat: &lat},
+		{Site: "a", KWp: 4, Lat: &lat, Lon: &far},
+		{Site: "a", KWp: 4, Commissioned: "01-04-2020"},
+	}
+	for _, m := range invalid {
+		if err := m.Validate(); err == nil {
+			t.Errorf("Expected an error for %+v", m)
+		}
+	}
+	if _, _, ok := valid[0].Location(); ok {
+		t.Errorf("Expected no location without lat and lon")
+	}
+}
@@ -0,0 +1,9 @@
+package models
+
+// EnvironmentalStatus holds the environmental benefit of a site as reported by
+// the vendor portal. CO2Avoided is in kg; TreesPlanted is nil when the portal
+// does not report an equivalent number of trees.
+type EnvironmentalStatus struct {
+	CO2Avoided   float64
+	TreesPlanted *float64
+}
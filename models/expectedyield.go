@@ -0,0 +1,18 @@
+package models
+
+// ExpectedYield holds the energy a site was expected to produce on a day and
+// the energy it did produce, both in Wh.
+type ExpectedYield struct {
+	Date     string
+	Expected float64
+	Actual   float64
+}
+
+// Ratio returns the actual energy as a fraction of the expected energy, or
+// false when nothing was expected.
+func (y ExpectedYield) Ratio() (float64, bool) {
+	if y.Expected <= 0 {
+		return 0, false
+	}
+	return y.Actual / y.Expected, true
+}
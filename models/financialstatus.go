@@ -0,0 +1,12 @@
+package models
+
+// FinancialStatus holds the income of a site as calculated by the vendor
+// portal from its configured tariff. The partial periods are nil when the
+// portal only reports the total.
+type FinancialStatus struct {
+	Currency    string
+	IncomeToday *float64
+	IncomeMonth *float64
+	IncomeYear  *float64
+	IncomeTotal float64
+}
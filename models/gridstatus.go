@@ -0,0 +1,20 @@
+package models
+
+// GridStatus holds the grid connection readings of a site. Powers are in W and
+// counters in Wh, matching SolarStatus. Counters the provider does not report
+// are nil.
+type GridStatus struct {
+	PowerImport  float64
+	PowerExport  float64
+	EnergyImport *float64
+	EnergyExport *float64
+	Tariffs      []GridTariff
+}
+
+// GridTariff holds the import and export counters of a single tariff, for
+// meters that register them separately.
+type GridTariff struct {
+	Tariff       string
+	EnergyImport float64
+	EnergyExport float64
+}
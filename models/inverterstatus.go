@@ -0,0 +1,33 @@
+package models
+
+// InverterStatus holds the readings of a single inverter of a site. Powers are
+// in W and energies in Wh, matching SolarStatus. The readings are nil when the
+// provider does not report them.
+type InverterStatus struct {
+	Serial       string
+	Name         string
+	Manufacturer string
+	Model        string
+	Mode         string
+	PowerNow     *float64
+	EnergyToday  *float64
+	EnergyTotal  *float64
+	ACVoltage    *float64
+	ACCurrent    *float64
+	ACFrequency  *float64
+	DCVoltage    *float64
+	Temperature  *float64
+	Warnings     []string
+	Strings      []StringStatus
+}
+
+// StringStatus holds the DC readings of a single panel, string or MPPT input.
+// The readings are nil when the provider does not report them.
+type StringStatus struct {
+	Name        string
+	PowerNow    *float64
+	EnergyToday *float64
+	EnergyTotal *float64
+	Voltage     *float64
+	Current     *float64
+}
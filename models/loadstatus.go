@@ -0,0 +1,9 @@
+package models
+
+// LoadStatus holds the consumption of a site. Power is in W and the counter in
+// Wh, matching SolarStatus. The counter is nil when the provider does not report
+// it.
+type LoadStatus struct {
+	PowerNow    float64
+	EnergyTotal *float64
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// SiteMetadata describes the system of a site, so sites of different sizes
+// can be compared. Tilt and azimuth are in degrees, with azimuth 180 facing
+// south; Commissioned is a YYYY-MM-DD date. Lat and Lon are nil when the
+// location is not configured.
+type SiteMetadata struct {
+	Site         string   `yaml:"site"`
+	KWp          float64  `yaml:"kwp"`
+	Tilt         float64  `yaml:"tilt"`
+	Azimuth      float64  `yaml:"azimuth"`
+	Lat          *float64 `yaml:"lat"`
+	Lon          *float64 `yaml:"lon"`
+	Commissioned string   `yaml:"commissioned"`
+}
+
+// Location returns the latitude and longitude of the site, or false when they
+// are not configured.
+func (m SiteMetadata) Location() (lat, lon float64, ok bool) {
+	if m.Lat == nil || m.Lon == nil {
+		return 0, 0, false
+	}
+	return *m.Lat, *m.Lon, true
+}
+
+func (m SiteMetadata) Validate() error {
+	if m.KWp <= 0 {
+		return fmt.Errorf("%s - kwp must be positive", m.Site)
+	}
+	if m.Commissioned != "" {
+		if _, err := time.Parse("2006-01-02", m.Commissioned); err != nil {
+			return fmt.Errorf("%s - invalid commissioned date [%s], expected YYYY-MM-DD", m.Site, m.Commissioned)
+		}
+	}
+	if (m.Lat == nil) != (m.Lon == nil) {
+		return fmt.Errorf("%s - lat and lon must be set together", m.Site)
+	}
+	if lat, lon, ok := m.Location(); ok && (lat < -90 || lat > 90 || lon < -180 || lon > 180) {
+		return fmt.Errorf("%s - invalid location %f, %f", m.Site, lat, lon)
+	}
+	return nil
+}
+
+// SpecificYield returns energy in Wh as kWh per installed kWp.
+func (m SiteMetadata) SpecificYield(energy float64) float64 {
+	return energy / 1000 / m.KWp
+}
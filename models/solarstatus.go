@@ -1,9 +1,20 @@
 package models
 
+// SolarStatus holds the readings of a site. Powers are in W and energies in
+// Wh. The optional readings are nil when the provider does not report them,
+// so a missing battery or meter is not mistaken for one reading zero.
 type SolarStatus struct {
 	EnergyToday float64
 	EnergyMonth float64
 	EnergyYear  float64
 	EnergyTotal float64
 	PowerNow    float64
+
+	Battery   *BatteryStatus
+	Grid      *GridStatus
+	Load      *LoadStatus
+	Inverters []InverterStatus
+
+	Financial     *FinancialStatus
+	Environmental *EnvironmentalStatus
 }
@@ -0,0 +1,127 @@
+package performance
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+// Normalizations of the daily performance index a degradation is computed
+// from, from best to worst at removing the weather.
+const (
+	NormalizedExpected = "expected"
+	NormalizedPeers    = "peers"
+	NormalizedNone     = "specific_yield"
+)
+
+// minPairs is the number of days that need a measurement a year earlier for a
+// degradation estimate.
+const minPairs = 30
+
+// Index maps days to a daily performance index, like the specific yield or
+// the actual energy as a fraction of the expected energy.
+type Index map[string]float64
+
+// SpecificYieldIndex returns the daily energy in Wh as kWh/kWp.
+func SpecificYieldIndex(daily map[string]float64, m models.SiteMetadata) Index {
+	index := Index{}
+	for day, energy := range daily {
+		index[day] = m.SpecificYield(energy)
+	}
+	return index
+}
+
+// ExpectedIndex returns the actual energy as a fraction of the expected
+// energy for every day with an expectation.
+func ExpectedIndex(yields []models.ExpectedYield) Index {
+	index := Index{}
+	for _, y := range yields {
+		if ratio, ok := y.Ratio(); ok {
+			index[y.Date] = ratio
+		}
+	}
+	return index
+}
+
+// PeerIndex returns the specific yield of a site as a fraction of the median
+// specific yield of its peers, for every day at least two peers have.
+func PeerIndex(site Index, peers []Index) Index {
+	index := Index{}
+	for day, value := range site {
+		var values []float64
+		for _, peer := range peers {
+			if v, ok := peer[day]; ok && v > 0 {
+				values = append(values, v)
+			}
+		}
+		if len(values) < 2 {
+			continue
+		}
+		index[day] = value / median(values)
+	}
+	return index
+}
+
+// YearIndex is the mean performance index of a year.
+type YearIndex struct {
+	Year  string  `json:"year"`
+	Index float64 `json:"index"`
+	Days  int     `json:"days"`
+}
+
+// Degradation is the estimated loss of output of a site in percent per year;
+// a negative rate is an improvement. Pairs is the number of days compared
+// with the same day a year earlier.
+type Degradation struct {
+	Site          string      `json:"site"`
+	Normalization string      `json:"normalization"`
+	RatePerYear   float64     `json:"rate_per_year"`
+	Pairs         int         `json:"pairs"`
+	Estimated     bool        `json:"estimated"`
+	Years         []YearIndex `json:"years"`
+}
+
+// Analyze estimates the degradation from a daily performance index with the
+// year-over-year method: every day is compared with the same day a year
+// earlier and the median of the changes is the yearly rate, so outages and
+// odd days hardly matter. Days with an index of zero are skipped as outages.
+func Analyze(site, normalization string, index Index) Degradation {
+	d := Degradation{Site: site, Normalization: normalization}
+
+	years := map[string]*YearIndex{}
+	var changes []float64
+	for day, value := range index {
+		if value <= 0 {
+			continue
+		}
+		t, err := time.Parse(dateFormat, day)
+		if err != nil {
+			continue
+		}
+		year, ok := years[day[:4]]
+		if !ok {
+			year = &YearIndex{Year: day[:4]}
+			years[day[:4]] = year
+		}
+		year.Index += value
+		year.Days++
+
+		if earlier, ok := index[t.AddDate(-1, 0, 0).Format(dateFormat)]; ok && earlier > 0 {
+			changes = append(changes, (value/earlier-1)*100)
+		}
+	}
+
+	for _, year := range years {
+		year.Index /= float64(year.Days)
+		d.Years = append(d.Years, *year)
+	}
+	sort.Slice(d.Years, func(i, j int) bool { return d.Years[i].Year < d.Years[j].Year })
+
+	d.Pairs = len(changes)
+	if d.Pairs >= minPairs {
+		d.RatePerYear = -median(changes)
+		d.Estimated = true
+	}
+	return d
+}
@@ -0,0 +1,57 @@
+package performance
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+// degradingIndex returns a seasonal daily index over the given years that
+// loses rate percent a year, with a few outages.
+func degradingIndex(years int, rate float64) Index {
+	index := Index{}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for t := start; t.Before(start.AddDate(years, 0, 0)); t = t.AddDate(0, 0, 1) {
+		age := t.Sub(start).Hours() / 24 / 365.25
+		season := 3 + 2*math.Sin(float64(t.YearDay())/365*2*math.Pi)
+		index[t.Format(dateFormat)] = season * math.Pow(1-rate/100, age)
+	}
+	index["2021-06-01"] = 0
+	index["2022-03-15"] = 0.1
+	return index
+}
+
+func TestAnalyze(t *testing.T) {
+	d := Analyze("test", NormalizedNone, degradingIndex(3, 0.8))
+	if !d.Estimated || math.Abs(d.RatePerYear-0.8) > 0.05 {
+		t.Errorf("Expected 0.8%%/year, got %+v", d)
+	}
+	if len(d.Years) != 3 || d.Years[0].Year != "2020" || d.Years[2].Index >= d.Years[0].Index {
+		t.Errorf("Expected three declining years, got %+v", d.Years)
+	}
+
+	if d := Analyze("test", NormalizedNone, degradingIndex(1, 0.8)); d.Estimated || d.Pairs != 0 {
+		t.Errorf("Expected no estimate from a single year, got %+v", d)
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	m := models.SiteMetadata{KWp: 4}
+	site := SpecificYieldIndex(map[string]float64{"2024-06-01": 20000, "2024-06-02": 8000}, m)
+	if site["2024-06-01"] != 5 {
+		t.Errorf("Expected 5 kWh/kWp, got %f", site["2024-06-01"])
+	}
+
+	peers := []Index{{"2024-06-01": 4, "2024-06-02": 2}, {"2024-06-01": 6}, {"2024-06-01": 5}}
+	index := PeerIndex(site, peers)
+	if len(index) != 1 || index["2024-06-01"] != 1 {
+		t.Errorf("Expected only the day with two peers, got %v", index)
+	}
+
+	index = ExpectedIndex([]models.ExpectedYield{{Date: "2024-06-01", Expected: 20, Actual: 15}, {Date: "2024-06-02"}})
+	if len(index) != 1 || index["2024-06-01"] != 0.75 {
+		t.Errorf("Expected 0.75 for the day with an expectation, got %v", index)
+	}
+}
@@ -0,0 +1,155 @@
+// Package performance estimates the energy a site should produce from its
+// metadata and the sun, and detects sites that keep producing less.
+package performance
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+	"github.com/rvben/solar_exporter/sun"
+)
+
+const dateFormat = "2006-01-02"
+
+// step is the integration step of the expected energy.
+const step = 10 * time.Minute
+
+// Config sets when a site is underperforming: when its actual energy stays
+// below Threshold times the expected energy for Days days in a row. Weather
+// is an optional CSV file with the measured irradiation, see Weather; without
+// it the expected energy is that of a clear sky, so cloudy days count as
+// underperforming unless the threshold is low. PerformanceRatio covers the
+// losses of the installation and defaults to 0.8.
+type Config struct {
+	Threshold        float64 `yaml:"threshold"`
+	Days             int     `yaml:"days"`
+	Weather          string  `yaml:"weather"`
+	PerformanceRatio float64 `yaml:"performance_ratio"`
+}
+
+func (c Config) Validate() error {
+	if c.Threshold <= 0 || c.Threshold > 1 {
+		return fmt.Errorf("threshold must be between 0 and 1")
+	}
+	if c.Days < 1 {
+		return fmt.Errorf("days must be at least 1")
+	}
+	if c.PerformanceRatio < 0 || c.PerformanceRatio > 1 {
+		return fmt.Errorf("performance_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// Result is the outcome of a poll. ExpectedToday is the energy expected for
+// the whole day and Ratio the energy produced so far as a fraction of the
+// energy expected so far. Weather reports whether a measurement was used.
+type Result struct {
+	ExpectedToday   float64
+	Ratio           float64
+	Weather         bool
+	Underperforming bool
+}
+
+// Monitor compares the energy of a site with the expected energy and keeps
+// the daily outcome in the site database.
+type Monitor struct {
+	array   sun.Array
+	config  Config
+	weather *Weather
+	db      *models.DataBase
+}
+
+// NewMonitor returns a monitor for the site of m, which needs a location.
+func NewMonitor(m models.SiteMetadata, config Config, db *models.DataBase) *Monitor {
+	lat, lon, _ := m.Location()
+	monitor := &Monitor{
+		array:  sun.Array{Lat: lat, Lon: lon, Tilt: m.Tilt, Azimuth: m.Azimuth, KWp: m.KWp, PerformanceRatio: config.PerformanceRatio},
+		config: config,
+		db:     db,
+	}
+	if config.Weather != "" {
+		monitor.weather = NewWeather(config.Weather)
+	}
+	return monitor
+}
+
+// clearSkyIrradiation returns the global horizontal irradiation of a clear sky
+// between from and to in kWh/m².
+func (m *Monitor) clearSkyIrradiation(from, to time.Time) float64 {
+	irradiation := 0.0
+	for t := from; t.Before(to); t = t.Add(step) {
+		p := sun.PositionAt(t.Add(step/2), m.array.Lat, m.array.Lon)
+		irradiation += sun.ClearSky(p).GHI * step.Hours()
+	}
+	return irradiation / 1000
+}
+
+// weatherFactor returns the measured irradiation of the day as a fraction of
+// the clear-sky irradiation, or false without a measurement.
+func (m *Monitor) weatherFactor(midnight time.Time) (float64, bool, error) {
+	if m.weather == nil {
+		return 0, false, nil
+	}
+	measured, ok, err := m.weather.Irradiation(midnight.Format(dateFormat))
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	clearSky := m.clearSkyIrradiation(midnight, midnight.AddDate(0, 0, 1))
+	if clearSky <= 0 {
+		return 0, false, nil
+	}
+	return measured / clearSky, true, nil
+}
+
+// Expected returns the energy in Wh the site is expected to produce on the day
+// of now, for the whole day and up to now.
+func (m *Monitor) Expected(now time.Time) (day, soFar float64, weather bool, err error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	day = m.array.Energy(midnight, midnight.AddDate(0, 0, 1), step)
+	soFar = m.array.Energy(midnight, now, step)
+	factor, weather, err := m.weatherFactor(midnight)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if weather {
+		day *= factor
+		soFar *= factor
+	}
+	return day, soFar, weather, nil
+}
+
+// Record compares the energy produced today with the expected energy, stores
+// the outcome and checks the previous days for underperformance.
+func (m *Monitor) Record(status *models.SolarStatus, now time.Time) (Result, error) {
+	day, soFar, weather, err := m.Expected(now)
+	if err != nil {
+		return Result{}, err
+	}
+	today := models.ExpectedYield{Date: now.Format(dateFormat), Expected: soFar, Actual: status.EnergyToday}
+	if err := m.db.SaveExpectedYield(today); err != nil {
+		return Result{}, err
+	}
+	result := Result{ExpectedToday: day, Weather: weather}
+	result.Ratio, _ = today.Ratio()
+	result.Underperforming, err = m.underperforming(now)
+	return result, err
+}
+
+// underperforming reports whether each of the configured number of days
+// before now stayed below the threshold. A day without data ends the run.
+func (m *Monitor) underperforming(now time.Time) (bool, error) {
+	yields, err := m.db.GetExpectedYields(now.Format(dateFormat), m.config.Days)
+	if err != nil || len(yields) < m.config.Days {
+		return false, err
+	}
+	for i, y := range yields {
+		if y.Date != now.AddDate(0, 0, -i-1).Format(dateFormat) {
+			return false, nil
+		}
+		if ratio, ok := y.Ratio(); !ok || ratio >= m.config.Threshold {
+			return false, nil
+		}
+	}
+	return true, nil
+}
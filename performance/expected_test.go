@@ -0,0 +1,123 @@
+package performance
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+var testLat, testLon = 52.37, 4.89
+
+var testSite = models.SiteMetadata{Site: "test", KWp: 4, Tilt: 35, Azimuth: 180, Lat: &testLat, Lon: &testLon}
+
+func testDB(t *testing.T) *models.DataBase {
+	db, err := models.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+	return db
+}
+
+func TestExpected(t *testing.T) {
+	m := NewMonitor(testSite, Config{Threshold: 0.7, Days: 3}, testDB(t))
+	noon := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+	day, soFar, weather, err := m.Expected(noon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if weather {
+		t.Errorf("Expected no weather without a weather file")
+	}
+	if day < 4*5000 || day > 4*9000 {
+		t.Errorf("Expected 20 to 36 kWh on a clear summer day, got %f Wh", day)
+	}
+	if soFar <= day/3 || soFar >= day*2/3 {
+		t.Errorf("Expected about half of %f Wh at noon, got %f Wh", day, soFar)
+	}
+}
+
+func TestWeather(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "weather.csv")
+	if err := os.WriteFile(path, []byte("date,irradiation\n2024-06-21,4.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clear := NewMonitor(testSite, Config{Threshold: 0.7, Days: 3}, testDB(t))
+	cloudy := NewMonitor(testSite, Config{Threshold: 0.7, Days: 3, Weather: path}, testDB(t))
+	noon := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+
+	clearDay, _, _, _ := clear.Expected(noon)
+	cloudyDay, _, weather, err := cloudy.Expected(noon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	midnight := time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC)
+	factor := 4.0 / clear.clearSkyIrradiation(midnight, midnight.AddDate(0, 0, 1))
+	if !weather || math.Abs(cloudyDay-clearDay*factor) > 1e-6 {
+		t.Errorf("Expected %f Wh with the measured irradiation, got %f Wh (weather %v)", clearDay*factor, cloudyDay, weather)
+	}
+
+	// Days missing from the file fall back to a clear sky.
+	if _, _, weather, _ := cloudy.Expected(noon.AddDate(0, 0, 1)); weather {
+		t.Errorf("Expected no weather for a day without a measurement")
+	}
+
+	if err := os.WriteFile(path, []byte("2024-06-21,abc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	if _, _, _, err := cloudy.Expected(noon); err == nil {
+		t.Errorf("Expected an error for an invalid irradiation")
+	}
+}
+
+func TestUnderperforming(t *testing.T) {
+	m := NewMonitor(testSite, Config{Threshold: 0.7, Days: 3}, testDB(t))
+	evening := time.Date(2024, 6, 21, 23, 0, 0, 0, time.UTC)
+
+	record := func(day int, ratio float64) Result {
+		now := evening.AddDate(0, 0, day)
+		_, soFar, _, _ := m.Expected(now)
+		result, err := m.Record(&models.SolarStatus{EnergyToday: soFar * ratio}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	record(0, 0.5)
+	record(1, 0.5)
+	if result := record(2, 0.5); result.Underperforming || math.Abs(result.Ratio-0.5) > 1e-9 {
+		t.Errorf("Expected ratio 0.5 and no flag after two bad days, got %+v", result)
+	}
+	if result := record(3, 0.9); !result.Underperforming {
+		t.Errorf("Expected a flag after three bad days, got %+v", result)
+	}
+	if result := record(4, 0.9); result.Underperforming {
+		t.Errorf("Expected no flag after a good day, got %+v", result)
+	}
+	// A gap ends the run.
+	record(6, 0.5)
+	record(7, 0.5)
+	if result := record(8, 0.5); result.Underperforming {
+		t.Errorf("Expected no flag with a missing day, got %+v", result)
+	}
+	if result := record(9, 0.5); !result.Underperforming {
+		t.Errorf("Expected a flag after three consecutive bad days, got %+v", result)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, c := range []Config{{Threshold: 0, Days: 3}, {Threshold: 1.5, Days: 3}, {Threshold: 0.7}, {Threshold: 0.7, Days: 1, PerformanceRatio: 2}} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", c)
+		}
+	}
+	if err := (Config{Threshold: 0.7, Days: 3}).Validate(); err != nil {
+		t.Errorf("Expected a valid config, got %s", err)
+	}
+}
@@ -0,0 +1,149 @@
+package performance
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Peer comparison methods. Median scores a site by its distance from the
+// median in median absolute deviations, which a single broken site cannot
+// skew; ZScore uses the mean and standard deviation of the other sites.
+const (
+	Median = "median"
+	ZScore = "zscore"
+)
+
+// madScale makes the median absolute deviation comparable to a standard
+// deviation for normally distributed yields.
+const madScale = 1.4826
+
+// PeerConfig is a region of sites close enough to see the same weather. A
+// site is an anomaly when its score is beyond Threshold in either direction.
+// Days with a median yield below MinYield in kWh/kWp, like dark or early
+// hours, are not compared.
+type PeerConfig struct {
+	Name      string   `yaml:"name"`
+	Sites     []string `yaml:"sites"`
+	Method    string   `yaml:"method"`
+	Threshold float64  `yaml:"threshold"`
+	MinYield  float64  `yaml:"min_yield"`
+}
+
+func (c PeerConfig) Validate() error {
+	if len(c.Sites) < 3 {
+		return fmt.Errorf("region [%s] needs at least 3 sites", c.Name)
+	}
+	if c.Method != Median && c.Method != ZScore {
+		return fmt.Errorf("region [%s] has unknown method [%s], expected %s or %s", c.Name, c.Method, Median, ZScore)
+	}
+	if c.Threshold <= 0 {
+		return fmt.Errorf("region [%s] needs a positive threshold", c.Name)
+	}
+	return nil
+}
+
+type peerYield struct {
+	day   string
+	value float64
+}
+
+// PeerGroup compares the specific yield of the sites of a region. The sites
+// are polled at different times, so yields of a day in progress are not
+// comparable; the comparison uses the final yield of each site for the last
+// finished day, which is the last yield it reported before a new day.
+type PeerGroup struct {
+	config   PeerConfig
+	mu       sync.Mutex
+	current  map[string]peerYield
+	finished map[string]peerYield
+}
+
+func NewPeerGroup(config PeerConfig) *PeerGroup {
+	return &PeerGroup{config: config, current: map[string]peerYield{}, finished: map[string]peerYield{}}
+}
+
+func (g *PeerGroup) Name() string {
+	return g.config.Name
+}
+
+// Record stores the specific yield of site on day in kWh/kWp and returns the
+// anomaly score of its last finished day against the sites that finished the
+// same day. It returns false when the site has no finished day yet, fewer
+// than three sites finished that day or the yield is too low to compare.
+func (g *PeerGroup) Record(site, day string, specificYield float64) (score float64, anomaly, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if last, ok := g.current[site]; ok && last.day != day {
+		g.finished[site] = last
+	}
+	g.current[site] = peerYield{day: day, value: specificYield}
+
+	own, ok := g.finished[site]
+	if !ok {
+		return 0, false, false
+	}
+	var values, others []float64
+	for s, y := range g.finished {
+		if y.day != own.day {
+			continue
+		}
+		values = append(values, y.value)
+		if s != site {
+			others = append(others, y.value)
+		}
+	}
+	if len(values) < 3 {
+		return 0, false, false
+	}
+
+	center, spread := median(values), 0.0
+	if center < g.config.MinYield {
+		return 0, false, false
+	}
+	switch g.config.Method {
+	case Median:
+		deviations := make([]float64, len(values))
+		for i, v := range values {
+			deviations[i] = math.Abs(v - center)
+		}
+		spread = madScale * median(deviations)
+	case ZScore:
+		// A site counted in its own mean and deviation can score at most
+		// (n-1)/sqrt(n), so small regions could never reach the threshold.
+		center, spread = meanStdDev(others)
+	}
+	// Nearly identical peers would turn any difference into a huge score, so
+	// the spread is at least 1% of the typical yield.
+	spread = math.Max(spread, 0.01*center)
+	if spread == 0 {
+		return 0, false, false
+	}
+	score = (own.value - center) / spread
+	return score, math.Abs(score) > g.config.Threshold, true
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+func meanStdDev(values []float64) (float64, float64) {
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
@@ -0,0 +1,70 @@
+package performance
+
+import "testing"
+
+func TestPeerGroup(t *testing.T) {
+	for _, method := range []string{Median, ZScore} {
+		g := NewPeerGroup(PeerConfig{Name: "test", Sites: []string{"a", "b", "c", "d", "e"}, Method: method, Threshold: 1.5, MinYield: 0.5})
+		day, next := "2024-06-21", "2024-06-22"
+
+		// A day in progress is not compared.
+		for site, value := range map[string]float64{"a": 5.0, "b": 5.1, "c": 4.9, "d": 5.2, "e": 3.0} {
+			if _, _, ok := g.Record(site, day, value); ok {
+				t.Errorf("%s: expected no score before the day is finished", method)
+			}
+		}
+		if _, _, ok := g.Record("a", next, 0.1); ok {
+			t.Errorf("%s: expected no score with a single finished site", method)
+		}
+		g.Record("b", next, 0.1)
+		g.Record("c", next, 0.1)
+		g.Record("d", next, 0.1)
+		if score, anomaly, ok := g.Record("a", next, 0.2); !ok || anomaly || score > 1 || score < -1 {
+			t.Errorf("%s: expected a normal score for a typical site, got %f %v %v", method, score, anomaly, ok)
+		}
+		score, anomaly, ok := g.Record("e", next, 0.1)
+		if !ok || !anomaly || score >= 0 {
+			t.Errorf("%s: expected a negative anomaly for a site 40%% below its peers, got %f %v %v", method, score, anomaly, ok)
+		}
+	}
+}
+
+func TestPeerGroupSmallZScore(t *testing.T) {
+	// Counted in its own mean and deviation, an outlier of three sites could
+	// not score more than 2/sqrt(3).
+	g := NewPeerGroup(PeerConfig{Name: "test", Sites: []string{"a", "b", "c"}, Method: ZScore, Threshold: 2})
+	day, next := "2024-06-21", "2024-06-22"
+	g.Record("a", day, 5.0)
+	g.Record("b", day, 5.1)
+	g.Record("c", day, 3.0)
+	g.Record("a", next, 0)
+	g.Record("b", next, 0)
+	if _, anomaly, ok := g.Record("c", next, 0); !ok || !anomaly {
+		t.Errorf("Expected an anomaly in a region of three sites, got %v %v", anomaly, ok)
+	}
+}
+
+func TestPeerGroupMinYield(t *testing.T) {
+	g := NewPeerGroup(PeerConfig{Name: "test", Sites: []string{"a", "b", "c"}, Method: Median, Threshold: 3, MinYield: 0.5})
+	day, next := "2024-06-21", "2024-06-22"
+	g.Record("a", day, 0.1)
+	g.Record("b", day, 0.1)
+	g.Record("c", day, 0.0)
+	g.Record("a", next, 0)
+	g.Record("b", next, 0)
+	if _, _, ok := g.Record("c", next, 0); ok {
+		t.Errorf("Expected no comparison below the minimum yield")
+	}
+}
+
+func TestPeerConfigValidate(t *testing.T) {
+	for _, c := range []PeerConfig{
+		{Name: "few", Sites: []string{"a", "b"}, Method: Median, Threshold: 3},
+		{Name: "method", Sites: []string{"a", "b", "c"}, Method: "mean", Threshold: 3},
+		{Name: "threshold", Sites: []string{"a", "b", "c"}, Method: ZScore},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected region [%s] to be invalid", c.Name)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package performance
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Weather reads the measured daily irradiation of a location from a CSV file
+// with lines of "YYYY-MM-DD,irradiation", the global horizontal irradiation
+// of the day in kWh/m². A header line is skipped. The file is read again when
+// it changes, so a weather station or download job can keep appending to it.
+type Weather struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	days    map[string]float64
+}
+
+func NewWeather(path string) *Weather {
+	return &Weather{path: path}
+}
+
+// Irradiation returns the irradiation of day in kWh/m², or false when the file
+// has no measurement for it.
+func (w *Weather) Irradiation(day string) (float64, bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return 0, false, err
+	}
+	if w.days == nil || !info.ModTime().Equal(w.modTime) {
+		days, err := readWeather(w.path)
+		if err != nil {
+			return 0, false, err
+		}
+		w.days = days
+		w.modTime = info.ModTime()
+	}
+	value, ok := w.days[day]
+	return value, ok, nil
+}
+
+func readWeather(path string) (map[string]float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	days := map[string]float64{}
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return days, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read weather file [%s]: %s", path, err)
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("weather file [%s] line %d: expected date and irradiation", path, line)
+		}
+		day := strings.TrimSpace(record[0])
+		if _, err := time.Parse(dateFormat, day); err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("weather file [%s] line %d: invalid date [%s]", path, line, day)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("weather file [%s] line %d: could not convert [%s] to float: %s", path, line, record[1], err)
+		}
+		days[day] = value
+	}
+}
@@ -0,0 +1,56 @@
+// Package schedule decides when to poll a site next, so sites are polled at
+// their normal rate in daylight and hardly at night, when nothing changes.
+package schedule
+
+import (
+	"time"
+
+	"github.com/rvben/solar_exporter/sun"
+)
+
+// Config sets the polling at night. Margin widens the daylight window before
+// sunrise and after sunset, so the first watts and the final daily total are
+// seen. At night a site is polled every NightInterval, or with zero only once
+// after the window for the final total.
+type Config struct {
+	Margin        time.Duration `yaml:"margin"`
+	NightInterval time.Duration `yaml:"night_interval"`
+}
+
+// Schedule is the polling schedule of a site at a location.
+type Schedule struct {
+	config Config
+	lat    float64
+	lon    float64
+}
+
+func New(config Config, lat, lon float64) *Schedule {
+	return &Schedule{config: config, lat: lat, lon: lon}
+}
+
+// Next returns how long to wait after a poll at now, for a site polled every
+// interval in daylight.
+func (s *Schedule) Next(now time.Time, interval time.Duration) time.Duration {
+	start, _, ok := sun.NextDaylight(now, s.lat, s.lon, s.config.Margin)
+	switch {
+	case !ok:
+		// Midnight sun or polar night; poll at the normal rate if the sun
+		// is up at all.
+		if sun.PositionAt(now, s.lat, s.lon).Elevation > 0 {
+			return interval
+		}
+		return s.night(24 * time.Hour)
+	case now.Before(start):
+		// The first poll after the daylight got the final total.
+		return s.night(start.Sub(now))
+	}
+	return interval
+}
+
+// night returns the wait at night, until dawn in at most NightInterval steps.
+func (s *Schedule) night(untilDawn time.Duration) time.Duration {
+	if s.config.NightInterval > 0 && s.config.NightInterval < untilDawn {
+		return s.config.NightInterval
+	}
+	return untilDawn
+}
@@ -0,0 +1,74 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skip("no time zone data")
+	}
+	at := func(clock string) time.Time {
+		c, _ := time.Parse("15:04", clock)
+		return time.Date(2024, 6, 21, c.Hour(), c.Minute(), 0, 0, amsterdam)
+	}
+	interval := 5 * time.Minute
+
+	// Sunrise is around 05:18 and sunset around 22:06.
+	s := New(Config{Margin: 30 * time.Minute}, 52.37, 4.89)
+	if wait := s.Next(at("12:00"), interval); wait != interval {
+		t.Errorf("Expected the normal interval at noon, got %s", wait)
+	}
+	if wait := s.Next(at("22:20"), interval); wait != interval {
+		t.Errorf("Expected the normal interval within the margin after sunset, got %s", wait)
+	}
+	// The first poll after the window is the final total; the next one is at
+	// dawn.
+	if next := at("23:00").Add(s.Next(at("23:00"), interval)); next.Format("01-02 15:04") < "06-22 04:43" || next.Format("01-02 15:04") > "06-22 04:53" {
+		t.Errorf("Expected the next poll around 04:48 tomorrow, got %s", next)
+	}
+	if next := at("02:00").Add(s.Next(at("02:00"), interval)); next.Format("15:04") < "04:43" || next.Format("15:04") > "04:53" {
+		t.Errorf("Expected the next poll around 04:48, got %s", next)
+	}
+
+	s = New(Config{Margin: 30 * time.Minute, NightInterval: time.Hour}, 52.37, 4.89)
+	if wait := s.Next(at("23:00"), interval); wait != time.Hour {
+		t.Errorf("Expected the night interval, got %s", wait)
+	}
+	if wait := s.Next(at("04:30"), interval); wait >= time.Hour || wait <= 0 {
+		t.Errorf("Expected to wait until dawn, within the night interval, got %s", wait)
+	}
+}
+
+func TestNextPolar(t *testing.T) {
+	interval := 5 * time.Minute
+	s := New(Config{NightInterval: 2 * time.Hour}, 69.65, 18.96)
+	if wait := s.Next(time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC), interval); wait != 2*time.Hour {
+		t.Errorf("Expected the night interval in polar night, got %s", wait)
+	}
+	if wait := s.Next(time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), interval); wait != interval {
+		t.Errorf("Expected the normal interval in midnight sun, got %s", wait)
+	}
+}
+
+func TestNextAcrossMidnight(t *testing.T) {
+	interval := 5 * time.Minute
+
+	// The daylight of these sites spans midnight UTC, the server's time zone.
+	sydney := New(Config{Margin: 30 * time.Minute}, -33.87, 151.21)
+	if wait := sydney.Next(time.Date(2024, 12, 21, 2, 0, 0, 0, time.UTC), interval); wait != interval {
+		t.Errorf("Expected the normal interval at noon in Sydney, got %s", wait)
+	}
+	sanFrancisco := New(Config{Margin: 30 * time.Minute}, 37.77, -122.42)
+	if wait := sanFrancisco.Next(time.Date(2024, 6, 21, 1, 0, 0, 0, time.UTC), interval); wait != interval {
+		t.Errorf("Expected the normal interval at 18:00 in San Francisco, got %s", wait)
+	}
+	// After sunset, around 03:35 UTC, it waits for dawn, around 12:48 UTC.
+	next := time.Date(2024, 6, 21, 5, 0, 0, 0, time.UTC)
+	next = next.Add(sanFrancisco.Next(next, interval))
+	if next.Format("15:04") < "12:10" || next.Format("15:04") > "12:30" {
+		t.Errorf("Expected the next poll half an hour before the sunrise in San Francisco, got %s", next)
+	}
+}
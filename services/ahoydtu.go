@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+// ahoyDTULive is the summary of an AhoyDTU gateway. It names the fields of the
+// AC channel and of the DC channels of every inverter; the inverter data only
+// holds their values. Iv lists whether each inverter id is enabled.
+type ahoyDTULive struct {
+	Ch0FieldNames []string `json:"ch0_fld_names"`
+	Ch0FieldUnits []string `json:"ch0_fld_units"`
+	FieldNames    []string `json:"fld_names"`
+	FieldUnits    []string `json:"fld_units"`
+	Iv            []bool   `json:"iv"`
+}
+
+// ahoyDTUInverter holds the channels of an inverter: the AC channel first,
+// followed by a channel per panel. Values the inverter has not reported yet
+// are null.
+type ahoyDTUInverter struct {
+	Name         string       `json:"name"`
+	Serial       string       `json:"serial"`
+	Channels     [][]*float64 `json:"ch"`
+	ChannelNames []string     `json:"ch_name"`
+}
+
+// ahoyDTUFields returns the values of a channel by field name, in W and Wh.
+func ahoyDTUFields(names, units []string, values []*float64) map[string]*float64 {
+	fields := map[string]*float64{}
+	for i, name := range names {
+		if i >= len(values) || values[i] == nil {
+			continue
+		}
+		value := *values[i]
+		if i < len(units) && (units[i] == "kW" || units[i] == "kWh") {
+			value *= 1000
+		}
+		fields[name] = &value
+	}
+	return fields
+}
+
+// AhoyDTUProvider reads the live data of Hoymiles microinverters from the REST
+// API of an AhoyDTU gateway.
+type AhoyDTUProvider struct {
+	site    string
+	baseURL string
+	timeout int
+	db      *models.DataBase
+	client  *http.Client
+
+	mu        sync.Mutex
+	inverters []models.InverterStatus
+}
+
+func (p *AhoyDTUProvider) Site() string {
+	return p.site
+}
+
+func (p *AhoyDTUProvider) Timeout() int {
+	return p.timeout
+}
+
+func (p *AhoyDTUProvider) DB() *models.DataBase {
+	return p.db
+}
+
+func NewAhoyDTUProvider(site, baseURL string, timeout int, db *models.DataBase) *AhoyDTUProvider {
+	return &AhoyDTUProvider{site: site, baseURL: strings.TrimRight(baseURL, "/"), timeout: timeout, db: db, client: &http.Client{}}
+}
+
+func (p *AhoyDTUProvider) Transport() http.RoundTripper {
+	return p.client.Transport
+}
+
+func (p *AhoyDTUProvider) SetTransport(t http.RoundTripper) {
+	p.client.Transport = t
+}
+
+func (p *AhoyDTUProvider) get(ctx context.Context, path string, v interface{}) error {
+	url := p.baseURL + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("could not create request for url [%s]: %s", url, err)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could succesfully finish request [%s]: %s", url, err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read body from request: %s", err)
+	}
+	if res.StatusCode != 200 {
+		return &statusError{code: res.StatusCode, status: res.Status}
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to parse body to json: %s", err)
+	}
+	return nil
+}
+
+func (p *AhoyDTUProvider) GetSolarStatus() (*models.SolarStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
+	defer cancel()
+
+	live := ahoyDTULive{}
+	if err := p.get(ctx, "/api/live", &live); err != nil {
+		return nil, err
+	}
+
+	var inverters []models.InverterStatus
+	powerNow, energyToday, energyTotal := 0.0, 0.0, 0.0
+	for id, enabled := range live.Iv {
+		if !enabled {
+			continue
+		}
+		inv := ahoyDTUInverter{}
+		if err := p.get(ctx, fmt.Sprintf("/api/inverter/id/%d", id), &inv); err != nil {
+			return nil, err
+		}
+		if len(inv.Channels) == 0 {
+			continue
+		}
+
+		ac := ahoyDTUFields(live.Ch0FieldNames, live.Ch0FieldUnits, inv.Channels[0])
+		inverter := models.InverterStatus{
+			Serial:      inv.Serial,
+			Name:        inv.Name,
+			PowerNow:    ac["P_AC"],
+			EnergyToday: ac["YieldDay"],
+			EnergyTotal: ac["YieldTotal"],
+			ACVoltage:   ac["U_AC"],
+			ACCurrent:   ac["I_AC"],
+			ACFrequency: ac["F_AC"],
+			Temperature: ac["Temp"],
+		}
+		if inverter.PowerNow != nil {
+			powerNow += *inverter.PowerNow
+		}
+		if inverter.EnergyToday != nil {
+			energyToday += *inverter.EnergyToday
+		}
+		if inverter.EnergyTotal != nil {
+			energyTotal += *inverter.EnergyTotal
+		}
+
+		for ch := 1; ch < len(inv.Channels); ch++ {
+			dc := ahoyDTUFields(live.FieldNames, live.FieldUnits, inv.Channels[ch])
+			name := strconv.Itoa(ch)
+			if ch < len(inv.ChannelNames) && inv.ChannelNames[ch] != "" {
+				name = inv.ChannelNames[ch]
+			}
+			inverter.Strings = append(inverter.Strings, models.StringStatus{
+				Name:        name,
+				PowerNow:    dc["P_DC"],
+				EnergyToday: dc["YieldDay"],
+				EnergyTotal: dc["YieldTotal"],
+				Voltage:     dc["U_DC"],
+				Current:     dc["I_DC"],
+			})
+		}
+		inverters = append(inverters, inverter)
+	}
+
+	p.mu.Lock()
+	p.inverters = inverters
+	p.mu.Unlock()
+
+	status := models.SolarStatus{EnergyToday: energyToday, EnergyTotal: energyTotal, PowerNow: powerNow, Inverters: inverters}
+	return &status, nil
+}
+
+func (p *AhoyDTUProvider) Inverters() []models.InverterStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.inverters
+}
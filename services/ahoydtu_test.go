@@ -0,0 +1,54 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestAhoyDTUGetSolarStatus(t *testing.T) {
+	fixtures := map[string]string{
+		"/api/live":          "testdata/ahoydtu_live.json",
+		"/api/inverter/id/0": "testdata/ahoydtu_inverter_0.json",
+		"/api/inverter/id/2": "testdata/ahoydtu_inverter_2.json",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fixture, ok := fixtures[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, err := os.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	provider := NewAhoyDTUProvider("Site", server.URL, 10, nil)
+	status, err := provider.GetSolarStatus()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.PowerNow != 450.5 || status.EnergyToday != 1500 || status.EnergyTotal != 400250 {
+		t.Errorf("Expected the totals of both inverters, got %+v", status)
+	}
+
+	inverters := provider.Inverters()
+	if len(inverters) != 2 {
+		t.Fatalf("Expected 2 enabled inverters, got %d", len(inverters))
+	}
+	roof := inverters[0]
+	if roof.Serial != "116181234567" || *roof.PowerNow != 350.5 || *roof.EnergyTotal != 321500 || *roof.ACVoltage != 230.1 || *roof.Temperature != 35.2 {
+		t.Errorf("Unexpected inverter status: %+v", roof)
+	}
+	if len(roof.Strings) != 2 || roof.Strings[1].Name != "West" || *roof.Strings[1].Voltage != 32.1 || *roof.Strings[1].EnergyTotal != 161400 {
+		t.Errorf("Unexpected string status: %+v", roof.Strings)
+	}
+	shed := inverters[1]
+	if shed.Name != "Shed" || shed.Strings[0].Name != "1" || *shed.Strings[0].PowerNow != 104 || shed.Strings[0].EnergyToday != nil {
+		t.Errorf("Unexpected inverter status: %+v", shed)
+	}
+}
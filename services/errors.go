@@ -0,0 +1,48 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+)
+
+// LoginError is returned when a provider cannot log in to the vendor portal,
+// as opposed to failing to fetch or parse the data. Wrong credentials will not
+// fix themselves, so these are worth telling apart.
+type LoginError struct {
+	Site string
+	User string
+	Err  error
+}
+
+func (e *LoginError) Error() string {
+	if e.User == "" {
+		return fmt.Sprintf("failed to log in: %s", e.Err)
+	}
+	return fmt.Sprintf("failed to log in as user [%s]: %s", e.User, e.Err)
+}
+
+func (e *LoginError) Unwrap() error {
+	return e.Err
+}
+
+// statusError is returned when a server answers with an unexpected status
+// code, so callers can tell rejected credentials from other failures.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status code error: %d %s", e.code, e.status)
+}
+
+// unauthorized reports whether err is a response rejecting the credentials.
+func unauthorized(err error) bool {
+	var status *statusError
+	return errors.As(err, &status) && (status.code == 401 || status.code == 403)
+}
+
+// ErrPollSkipped is returned by providers that skip a poll, for example to
+// stay within an API quota. There is no new reading, so nothing should be
+// saved or recorded for the poll.
+var ErrPollSkipped = errors.New("poll skipped")
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+const fusionSolarDefaultBaseURL = "https://eu5.fusionsolar.huawei.com"
+
+// Northbound API fail codes we act upon.
+const (
+	fusionSolarNotLoggedIn = 305
+	fusionSolarRateLimited = 407
+)
+
+// fusionSolarIntervals holds the minimum time between two calls of the same
+// interface, as documented in the Northbound API reference. Calls made sooner
+// are answered from the cache instead of hitting the API.
+var fusionSolarIntervals = map[string]time.Duration{
+	"/thirdData/getDevList":        time.Hour,
+	"/thirdData/getStationRealKpi": 5 * time.Minute,
+	"/thirdData/getDevRealKpi":     5 * time.Minute,
+}
+
+// fusionSolarInverterType is the devTypeId of a string inverter.
+const fusionSolarInverterType = 1
+
+type FusionSolarProvider struct {
+	site        string
+	username    string
+	systemCode  string
+	stationCode string
+	baseURL     string
+	timeout     int
+	db          *models.DataBase
+
+	mu        sync.Mutex
+	client    *http.Client
+	xsrfToken string
+	lastCall  map[string]time.Time
+	cache     map[string]json.RawMessage
+}
+
+func (p *FusionSolarProvider) Site() string {
+	return p.site
+}
+
+func (p *FusionSolarProvider) Timeout() int {
+	return p.timeout
+}
+
+func (p *FusionSolarProvider) DB() *models.DataBase {
+	return p.db
+}
+
+func NewFusionSolarProvider(site, baseURL, username, systemCode, stationCode string, timeout int, db *models.DataBase) *FusionSolarProvider {
+	if baseURL == "" {
+		baseURL = fusionSolarDefaultBaseURL
+	}
+	return &FusionSolarProvider{
+		site:        site,
+		username:    username,
+		systemCode:  systemCode,
+		stationCode: stationCode,
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		timeout:     timeout,
+		db:          db,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		lastCall:    map[string]time.Time{},
+		cache:       map[string]json.RawMessage{},
+	}
+}
+
+func (p *FusionSolarProvider) Transport() http.RoundTripper {
+	return p.client.Transport
+}
+
+func (p *FusionSolarProvider) SetTransport(t http.RoundTripper) {
+	p.client.Transport = t
+}
+
+type fusionSolarResponse struct {
+	Success  bool            `json:"success"`
+	FailCode int             `json:"failCode"`
+	Message  string          `json:"message"`
+	Data     json.RawMessage `json:"data"`
+}
+
+func (p *FusionSolarProvider) post(ctx context.Context, path string, payload interface{}) (*http.Response, []byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	url := p.baseURL + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create request for url [%s]: %s", url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.xsrfToken != "" {
+		req.Header.Set("XSRF-TOKEN", p.xsrfToken)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could succesfully finish request [%s]: %s", url, err)
+	}
+	defer res.Body.Close()
+
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read body from request: %s", err)
+	}
+	if res.StatusCode != 200 {
+		return nil, nil, &statusError{code: res.StatusCode, status: res.Status}
+	}
+	return res, bodyBytes, nil
+}
+
+func (p *FusionSolarProvider) login(ctx context.Context) error {
+	log.Printf("%s - Logging in to FusionSolar as user [%s]", p.site, p.username)
+	p.xsrfToken = ""
+	res, body, err := p.post(ctx, "/thirdData/login", map[string]string{
+		"userName":   p.username,
+		"systemCode": p.systemCode,
+	})
+	if unauthorized(err) {
+		return &LoginError{Site: p.site, User: p.username, Err: err}
+	}
+	if err != nil {
+		return err
+	}
+
+	response := fusionSolarResponse{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("failed to parse body to json: %s", err)
+	}
+	if !response.Success {
+		return &LoginError{Site: p.site, User: p.username, Err: fmt.Errorf("%d %s", response.FailCode, response.Message)}
+	}
+
+	token := res.Header.Get("xsrf-token")
+	if token == "" {
+		for _, c := range res.Cookies() {
+			if c.Name == "XSRF-TOKEN" {
+				token = c.Value
+			}
+		}
+	}
+	if token == "" {
+		return &LoginError{Site: p.site, User: p.username, Err: fmt.Errorf("could not find XSRF-TOKEN in login response")}
+	}
+	p.xsrfToken = token
+	return nil
+}
+
+// call invokes a Northbound interface and returns its data section. It logs in
+// when no token is present or the token has expired, and serves the cached
+// result when the interface was called within its documented interval.
+func (p *FusionSolarProvider) call(ctx context.Context, path string, payload interface{}) (json.RawMessage, error) {
+	if last, ok := p.lastCall[path]; ok && time.Since(last) < fusionSolarIntervals[path] {
+		if data, ok := p.cache[path]; ok {
+			return data, nil
+		}
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if p.xsrfToken == "" {
+			if err := p.login(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		_, body, err := p.post(ctx, path, payload)
+		if err != nil {
+			return nil, err
+		}
+		response := fusionSolarResponse{}
+		if err := json.Unmarshal(body, &response); err != nil {
+			return nil, fmt.Errorf("failed to parse body to json: %s", err)
+		}
+
+		switch {
+		case response.FailCode == fusionSolarNotLoggedIn:
+			p.xsrfToken = ""
+			continue
+		case response.FailCode == fusionSolarRateLimited:
+			p.lastCall[path] = time.Now()
+			if data, ok := p.cache[path]; ok {
+				log.Printf("%s - FusionSolar rate limit hit for [%s], using cached data", p.site, path)
+				return data, nil
+			}
+			return nil, fmt.Errorf("rate limit exceeded for [%s]", path)
+		case !response.Success:
+			return nil, fmt.Errorf("request [%s] failed: %d %s", path, response.FailCode, response.Message)
+		}
+
+		p.lastCall[path] = time.Now()
+		p.cache[path] = response.Data
+		return response.Data, nil
+	}
+	return nil, fmt.Errorf("not logged in after re-login for [%s]", path)
+}
+
+func (p *FusionSolarProvider) GetSolarStatus() (*models.SolarStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
+	defer cancel()
+
+	data, err := p.call(ctx, "/thirdData/getStationRealKpi", map[string]string{"stationCodes": p.stationCode})
+	if err != nil {
+		return nil, err
+	}
+	stations := []struct {
+		StationCode string `json:"stationCode"`
+		DataItemMap struct {
+			DayPower   float64 `json:"day_power"`
+			MonthPower float64 `json:"month_power"`
+			TotalPower float64 `json:"total_power"`
+		} `json:"dataItemMap"`
+	}{}
+	if err := json.Unmarshal(data, &stations); err != nil {
+		return nil, fmt.Errorf("failed to parse station kpi: %s", err)
+	}
+	if len(stations) == 0 {
+		return nil, fmt.Errorf("no station found with code [%s]", p.stationCode)
+	}
+
+	data, err = p.call(ctx, "/thirdData/getDevList", map[string]string{"stationCodes": p.stationCode})
+	if err != nil {
+		return nil, err
+	}
+	devices := []struct {
+		ID        int64 `json:"id"`
+		DevTypeID int   `json:"devTypeId"`
+	}{}
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return nil, fmt.Errorf("failed to parse device list: %s", err)
+	}
+	var devIds []string
+	for _, d := range devices {
+		if d.DevTypeID == fusionSolarInverterType {
+			devIds = append(devIds, fmt.Sprintf("%d", d.ID))
+		}
+	}
+
+	powerNow := 0.0
+	if len(devIds) > 0 {
+		data, err = p.call(ctx, "/thirdData/getDevRealKpi", map[string]string{
+			"devIds":    strings.Join(devIds, ","),
+			"devTypeId": fmt.Sprintf("%d", fusionSolarInverterType),
+		})
+		if err != nil {
+			return nil, err
+		}
+		inverters := []struct {
+			DevID       int64 `json:"devId"`
+			DataItemMap struct {
+				ActivePower float64 `json:"active_power"`
+			} `json:"dataItemMap"`
+		}{}
+		if err := json.Unmarshal(data, &inverters); err != nil {
+			return nil, fmt.Errorf("failed to parse device kpi: %s", err)
+		}
+		for _, i := range inverters {
+			powerNow += i.DataItemMap.ActivePower * 1000 // active_power is in kW
+		}
+	}
+
+	d := stations[0].DataItemMap
+	energyToday := d.DayPower * 1000   // day_power is in kWh
+	energyMonth := d.MonthPower * 1000 // month_power is in kWh
+	energyTotal := d.TotalPower * 1000 // total_power is in kWh
+	status := models.SolarStatus{EnergyToday: energyToday, EnergyMonth: energyMonth, EnergyTotal: energyTotal, PowerNow: powerNow}
+	return &status, nil
+}
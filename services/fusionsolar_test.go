@@ -0,0 +1,89 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newFakeFusionSolarServer(t *testing.T, calls map[string]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[r.URL.Path]++
+		if r.URL.Path == "/thirdData/login" {
+			w.Header().Set("xsrf-token", "token-1")
+			w.Write([]byte(`{"success":true,"failCode":0,"data":null}`))
+			return
+		}
+		if r.Header.Get("XSRF-TOKEN") != "token-1" {
+			w.Write([]byte(`{"success":false,"failCode":305,"message":"USER_MUST_RELOGIN"}`))
+			return
+		}
+		payload := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Unexpected request body: %v", err)
+		}
+		switch r.URL.Path {
+		case "/thirdData/getStationRealKpi":
+			w.Write([]byte(`{"success":true,"failCode":0,"data":[{"stationCode":"NE=1","dataItemMap":{"day_power":12.5,"month_power":300.25,"total_power":4000}}]}`))
+		case "/thirdData/getDevList":
+			w.Write([]byte(`{"success":true,"failCode":0,"data":[{"id":11,"devTypeId":1},{"id":12,"devTypeId":1},{"id":13,"devTypeId":62}]}`))
+		case "/thirdData/getDevRealKpi":
+			if payload["devIds"] != "11,12" {
+				t.Errorf("Expected devIds 11,12, got %s", payload["devIds"])
+			}
+			w.Write([]byte(`{"success":true,"failCode":0,"data":[{"devId":11,"dataItemMap":{"active_power":1.5}},{"devId":12,"dataItemMap":{"active_power":0.25}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestFusionSolarGetSolarStatus(t *testing.T) {
+	calls := map[string]int{}
+	server := newFakeFusionSolarServer(t, calls)
+	defer server.Close()
+
+	provider := NewFusionSolarProvider("Site", server.URL, "user", "secret", "NE=1", 10, nil)
+	status, err := provider.GetSolarStatus()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.PowerNow != 1750 {
+		t.Errorf("Expected PowerNow 1750, got %f", status.PowerNow)
+	}
+	if status.EnergyToday != 12500 {
+		t.Errorf("Expected EnergyToday 12500, got %f", status.EnergyToday)
+	}
+	if status.EnergyMonth != 300250 {
+		t.Errorf("Expected EnergyMonth 300250, got %f", status.EnergyMonth)
+	}
+	if status.EnergyTotal != 4000000 {
+		t.Errorf("Expected EnergyTotal 4000000, got %f", status.EnergyTotal)
+	}
+
+	// A second poll within the documented interval must be served from cache.
+	if _, err := provider.GetSolarStatus(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for path, n := range calls {
+		if n != 1 {
+			t.Errorf("Expected 1 call to %s, got %d", path, n)
+		}
+	}
+}
+
+func TestFusionSolarRelogin(t *testing.T) {
+	calls := map[string]int{}
+	server := newFakeFusionSolarServer(t, calls)
+	defer server.Close()
+
+	provider := NewFusionSolarProvider("Site", server.URL, "user", "secret", "NE=1", 10, nil)
+	provider.xsrfToken = "expired"
+	if _, err := provider.GetSolarStatus(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if calls["/thirdData/login"] != 1 {
+		t.Errorf("Expected 1 login, got %d", calls["/thirdData/login"])
+	}
+}
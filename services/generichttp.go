@@ -0,0 +1,352 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+// GenericHTTPRequest describes one HTTP request of a generic_http site. URL,
+// header values and body are Go templates executed with the site variables
+// and the values extracted by earlier login steps.
+type GenericHTTPRequest struct {
+	URL            string            `yaml:"url"`
+	Method         string            `yaml:"method"`
+	Headers        map[string]string `yaml:"headers"`
+	Body           string            `yaml:"body"`
+	Extract        map[string]string `yaml:"extract"`
+	ExtractHeaders map[string]string `yaml:"extract_headers"`
+}
+
+// GenericHTTPField maps a JSONPath expression onto a SolarStatus field. The
+// selected value is multiplied by Multiplier, e.g. 1000 for kWh.
+type GenericHTTPField struct {
+	Path       string  `yaml:"path"`
+	Multiplier float64 `yaml:"multiplier"`
+}
+
+// GenericHTTPConfig defines a generic_http site entirely in the configuration.
+type GenericHTTPConfig struct {
+	Site    string                      `yaml:"site"`
+	Timeout int                         `yaml:"timeout"`
+	Vars    map[string]string           `yaml:"vars"`
+	Login   []GenericHTTPRequest        `yaml:"login"`
+	Request GenericHTTPRequest          `yaml:"request"`
+	Fields  map[string]GenericHTTPField `yaml:"fields"`
+}
+
+// solarStatusFields are the field names config-driven providers map onto
+// SolarStatus.
+var solarStatusFields = []string{
+	"power_now", "energy_today", "energy_month", "energy_year", "energy_total",
+	"battery_soc", "battery_power_charge", "battery_power_discharge", "battery_energy_charged", "battery_energy_discharged",
+	"grid_power_import", "grid_power_export", "grid_energy_import", "grid_energy_export",
+	"load_power_now", "load_energy_total",
+}
+
+func isSolarStatusField(name string) bool {
+	for _, n := range solarStatusFields {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFieldWithPrefix reports whether any of the values belongs to the given
+// group of fields, so groups without any field stay nil.
+func hasFieldWithPrefix(values map[string]float64, prefix string) bool {
+	for name := range values {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// optionalField returns the value of name, or nil when it is not configured.
+func optionalField(values map[string]float64, name string) *float64 {
+	if v, ok := values[name]; ok {
+		return &v
+	}
+	return nil
+}
+
+func solarStatusFromFields(values map[string]float64) *models.SolarStatus {
+	status := &models.SolarStatus{
+		EnergyToday: values["energy_today"],
+		EnergyMonth: values["energy_month"],
+		EnergyYear:  values["energy_year"],
+		EnergyTotal: values["energy_total"],
+		PowerNow:    values["power_now"],
+	}
+	if hasFieldWithPrefix(values, "battery_") {
+		status.Battery = &models.BatteryStatus{
+			StateOfCharge:    optionalField(values, "battery_soc"),
+			PowerCharge:      values["battery_power_charge"],
+			PowerDischarge:   values["battery_power_discharge"],
+			EnergyCharged:    optionalField(values, "battery_energy_charged"),
+			EnergyDischarged: optionalField(values, "battery_energy_discharged"),
+		}
+	}
+	if hasFieldWithPrefix(values, "grid_") {
+		status.Grid = &models.GridStatus{
+			PowerImport:  values["grid_power_import"],
+			PowerExport:  values["grid_power_export"],
+			EnergyImport: optionalField(values, "grid_energy_import"),
+			EnergyExport: optionalField(values, "grid_energy_export"),
+		}
+	}
+	if hasFieldWithPrefix(values, "load_") {
+		status.Load = &models.LoadStatus{
+			PowerNow:    values["load_power_now"],
+			EnergyTotal: optionalField(values, "load_energy_total"),
+		}
+	}
+	return status
+}
+
+type GenericHTTPProvider struct {
+	site    string
+	config  GenericHTTPConfig
+	timeout int
+	db      *models.DataBase
+
+	mu       sync.Mutex
+	client   *http.Client
+	fields   map[string]*jsonPath
+	session  map[string]string
+	loggedIn bool
+}
+
+func (p *GenericHTTPProvider) Site() string {
+	return p.site
+}
+
+func (p *GenericHTTPProvider) Timeout() int {
+	return p.timeout
+}
+
+func (p *GenericHTTPProvider) DB() *models.DataBase {
+	return p.db
+}
+
+// NewGenericHTTPProvider validates the templates and JSONPath expressions of
+// the configuration so mistakes surface at startup rather than on each poll.
+func NewGenericHTTPProvider(site string, config GenericHTTPConfig, timeout int, db *models.DataBase) (*GenericHTTPProvider, error) {
+	if config.Request.URL == "" {
+		return nil, fmt.Errorf("%s - request url is required", site)
+	}
+	requests := append([]GenericHTTPRequest{config.Request}, config.Login...)
+	for _, r := range requests {
+		templates := []string{r.URL, r.Body}
+		for _, h := range r.Headers {
+			templates = append(templates, h)
+		}
+		for _, t := range templates {
+			if _, err := template.New("").Option("missingkey=error").Parse(t); err != nil {
+				return nil, fmt.Errorf("%s - invalid template [%s]: %s", site, t, err)
+			}
+		}
+		for _, expr := range r.Extract {
+			if _, err := compileJSONPath(expr); err != nil {
+				return nil, fmt.Errorf("%s - %s", site, err)
+			}
+		}
+	}
+
+	fields := map[string]*jsonPath{}
+	for name, f := range config.Fields {
+		if !isSolarStatusField(name) {
+			return nil, fmt.Errorf("%s - unknown field [%s], expected one of %s", site, name, strings.Join(solarStatusFields, ", "))
+		}
+		path, err := compileJSONPath(f.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s - %s", site, err)
+		}
+		fields[name] = path
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%s - at least one field is required", site)
+	}
+
+	jar, _ := cookiejar.New(nil)
+	return &GenericHTTPProvider{
+		site:    site,
+		config:  config,
+		timeout: timeout,
+		db:      db,
+		client:  &http.Client{Timeout: 30 * time.Second, Jar: jar},
+		fields:  fields,
+	}, nil
+}
+
+func (p *GenericHTTPProvider) Transport() http.RoundTripper {
+	return p.client.Transport
+}
+
+func (p *GenericHTTPProvider) SetTransport(t http.RoundTripper) {
+	p.client.Transport = t
+}
+
+func (p *GenericHTTPProvider) render(text string) (string, error) {
+	t, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	data := map[string]string{}
+	for k, v := range p.config.Vars {
+		data[k] = v
+	}
+	for k, v := range p.session {
+		data[k] = v
+	}
+	var out strings.Builder
+	if err := t.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// do executes a configured request and returns the decoded JSON response and
+// the response headers.
+func (p *GenericHTTPProvider) do(ctx context.Context, r GenericHTTPRequest) (interface{}, http.Header, error) {
+	url, err := p.render(r.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not render url [%s]: %s", r.URL, err)
+	}
+	body, err := p.render(r.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not render body for url [%s]: %s", url, err)
+	}
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), url, bytes.NewReader([]byte(body)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create request for url [%s]: %s", url, err)
+	}
+	for name, value := range r.Headers {
+		value, err := p.render(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not render header [%s]: %s", name, err)
+		}
+		req.Header.Set(name, value)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could succesfully finish request [%s]: %s", url, err)
+	}
+	defer res.Body.Close()
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read body from request: %s", err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, nil, &statusError{code: res.StatusCode, status: res.Status}
+	}
+
+	var doc interface{}
+	if len(bytes.TrimSpace(bodyBytes)) > 0 {
+		if err := json.Unmarshal(bodyBytes, &doc); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse body to json: %s", err)
+		}
+	}
+	return doc, res.Header, nil
+}
+
+func (p *GenericHTTPProvider) login(ctx context.Context) error {
+	p.session = map[string]string{}
+	for i, step := range p.config.Login {
+		log.Printf("%s - Running login step %d", p.site, i+1)
+		doc, headers, err := p.do(ctx, step)
+		if unauthorized(err) {
+			return &LoginError{Site: p.site, Err: fmt.Errorf("login step %d failed: %s", i+1, err)}
+		}
+		if err != nil {
+			return fmt.Errorf("login step %d failed: %s", i+1, err)
+		}
+		// A step that succeeds without the values to extract did not log in.
+		for name, expr := range step.Extract {
+			path, _ := compileJSONPath(expr)
+			value, err := path.String(doc)
+			if err != nil {
+				return &LoginError{Site: p.site, Err: fmt.Errorf("login step %d failed: %s", i+1, err)}
+			}
+			p.session[name] = value
+		}
+		for name, header := range step.ExtractHeaders {
+			value := headers.Get(header)
+			if value == "" {
+				return &LoginError{Site: p.site, Err: fmt.Errorf("login step %d failed: header [%s] missing", i+1, header)}
+			}
+			p.session[name] = value
+		}
+	}
+	p.loggedIn = true
+	return nil
+}
+
+func (p *GenericHTTPProvider) fetch(ctx context.Context) (*models.SolarStatus, error) {
+	doc, _, err := p.do(ctx, p.config.Request)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]float64{}
+	for name, path := range p.fields {
+		v, err := path.Float(doc)
+		if err != nil {
+			return nil, err
+		}
+		multiplier := p.config.Fields[name].Multiplier
+		if multiplier == 0 {
+			multiplier = 1
+		}
+		values[name] = v * multiplier
+	}
+	return solarStatusFromFields(values), nil
+}
+
+// GetSolarStatus runs the login steps once and reuses the extracted session
+// values and cookies. When the request fails with an existing session, it logs
+// in again and retries once.
+func (p *GenericHTTPProvider) GetSolarStatus() (*models.SolarStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
+	defer cancel()
+
+	fresh := false
+	if !p.loggedIn {
+		if err := p.login(ctx); err != nil {
+			return nil, err
+		}
+		fresh = true
+	}
+	status, err := p.fetch(ctx)
+	if err == nil || fresh || len(p.config.Login) == 0 {
+		return status, err
+	}
+
+	log.Printf("%s - Request failed, logging in again: %s", p.site, err)
+	if err := p.login(ctx); err != nil {
+		p.loggedIn = false
+		return nil, err
+	}
+	return p.fetch(ctx)
+}
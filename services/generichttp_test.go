@@ -0,0 +1,128 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+const genericHTTPTestConfig = `
+vars:
+  user: alice
+  pass: secret
+login:
+  - url: "{{.base}}/login"
+    method: POST
+    headers:
+      Content-Type: application/json
+    body: '{"user":"{{.user}}","pass":"{{.pass}}"}'
+    extract:
+      token: $.data.token
+request:
+  url: "{{.base}}/status?station=1"
+  headers:
+    Authorization: "Bearer {{.token}}"
+fields:
+  power_now:
+    path: $.inverters[*].pac
+  energy_today:
+    path: $.eday
+    multiplier: 1000
+  energy_total:
+    path: $.etotal
+    multiplier: 1000
+`
+
+func TestGenericHTTPGetSolarStatus(t *testing.T) {
+	logins := 0
+	token := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			logins++
+			if r.Method != http.MethodPost {
+				t.Errorf("Expected POST, got %s", r.Method)
+			}
+			token = "token-" + string(rune('0'+logins))
+			w.Write([]byte(`{"data":{"token":"` + token + `"}}`))
+		case "/status":
+			if r.Header.Get("Authorization") != "Bearer "+token {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"inverters":[{"pac":1200},{"pac":"300.5"}],"eday":4.2,"etotal":1234.5}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := GenericHTTPConfig{}
+	if err := yaml.Unmarshal([]byte(genericHTTPTestConfig), &config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	config.Vars["base"] = server.URL
+
+	provider, err := NewGenericHTTPProvider("Site", config, 10, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	status, err := provider.GetSolarStatus()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.PowerNow != 1500.5 {
+		t.Errorf("Expected PowerNow 1500.5, got %f", status.PowerNow)
+	}
+	if status.EnergyToday != 4200 {
+		t.Errorf("Expected EnergyToday 4200, got %f", status.EnergyToday)
+	}
+	if status.EnergyTotal != 1234500 {
+		t.Errorf("Expected EnergyTotal 1234500, got %f", status.EnergyTotal)
+	}
+
+	// An expired token leads to a single new login.
+	token = "expired"
+	if _, err := provider.GetSolarStatus(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if logins != 2 {
+		t.Errorf("Expected 2 logins, got %d", logins)
+	}
+}
+
+func TestNewGenericHTTPProviderValidation(t *testing.T) {
+	config := GenericHTTPConfig{
+		Request: GenericHTTPRequest{URL: "http://localhost/{{.x"},
+		Fields:  map[string]GenericHTTPField{"power_now": {Path: "$.p"}},
+	}
+	if _, err := NewGenericHTTPProvider("Site", config, 10, nil); err == nil {
+		t.Errorf("Expected error for invalid template")
+	}
+	config.Request.URL = "http://localhost/"
+	config.Fields = map[string]GenericHTTPField{"power": {Path: "$.p"}}
+	if _, err := NewGenericHTTPProvider("Site", config, 10, nil); err == nil {
+		t.Errorf("Expected error for unknown field")
+	}
+}
+
+func TestSolarStatusFromFields(t *testing.T) {
+	status := solarStatusFromFields(map[string]float64{"power_now": 1500, "battery_soc": 0, "grid_power_export": 300})
+	if status.PowerNow != 1500 {
+		t.Errorf("Expected power 1500, got %f", status.PowerNow)
+	}
+	if status.Battery == nil || status.Battery.StateOfCharge == nil || *status.Battery.StateOfCharge != 0 {
+		t.Errorf("Expected an empty battery, got %+v", status.Battery)
+	}
+	if status.Grid == nil || status.Grid.PowerExport != 300 {
+		t.Errorf("Expected the grid export, got %+v", status.Grid)
+	}
+	if status.Load != nil {
+		t.Errorf("Expected no load without load fields, got %+v", status.Load)
+	}
+	if status.Battery.EnergyCharged != nil || status.Grid.EnergyExport != nil {
+		t.Errorf("Expected no counters without counter fields, got %+v and %+v", status.Battery, status.Grid)
+	}
+}
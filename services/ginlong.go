@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/url"
+	neturl "net/url"
 	"strings"
 	"time"
 
@@ -20,6 +20,7 @@ type GinlongProvider struct {
 	pid      string
 	timeout  int
 	db       *models.DataBase
+	client   *http.Client
 }
 
 func (p *GinlongProvider) Site() string {
@@ -35,32 +36,67 @@ func (p *GinlongProvider) DB() *models.DataBase {
 }
 
 func NewGinlongProvider(site, username, password, pid string, timeout int, db *models.DataBase) *GinlongProvider {
-	return &GinlongProvider{site: site, username: username, password: password, pid: pid, timeout: timeout, db: db}
+	return &GinlongProvider{site: site, username: username, password: password, pid: pid, timeout: timeout, db: db, client: &http.Client{}}
 }
 
-func (p *GinlongProvider) GetSolarStatus() (*models.SolarStatus, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
-	defer cancel()
+func (p *GinlongProvider) Transport() http.RoundTripper {
+	return p.client.Transport
+}
 
-	params := url.Values{}
+func (p *GinlongProvider) SetTransport(t http.RoundTripper) {
+	p.client.Transport = t
+}
+
+// GinlongPlant is a plant of a Ginlong account.
+type GinlongPlant struct {
+	ID   string
+	Name string
+}
+
+func (p *GinlongProvider) post(ctx context.Context, url, jsessionId string, params neturl.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("could not create request for url [%s]: %s", url, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if jsessionId != "" {
+		req.Header.Set("Cookie", fmt.Sprintf("JSESSIONID=%s", jsessionId))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could succesfully finish request [%s]: %s", url, err)
+	}
+	return resp, nil
+}
+
+// login returns the session id and the user id of the account.
+func (p *GinlongProvider) login(ctx context.Context) (string, string, error) {
+	params := neturl.Values{}
 	params.Add("userName", p.username)
 	params.Add("userNameDisplay", p.username)
 	params.Add("password", p.password)
 	params.Add("lan", `2`)
 	params.Add("userType", `C`)
-	body := strings.NewReader(params.Encode())
 
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://m.ginlong.com/cpro/login/validateLogin.json", body)
+	resp, err := p.post(ctx, "https://m.ginlong.com/cpro/login/validateLogin.json", "", params)
 	if err != nil {
-		// handle err
+		return "", "", err
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	defer resp.Body.Close()
 
-	resp, err := http.DefaultClient.Do(req)
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		// handle err
+		return "", "", fmt.Errorf("failed to read body from request: %s", err)
+	}
+	login := struct {
+		Result struct {
+			UserID json.Number `json:"userId"`
+		} `json:"result"`
+		State int `json:"state"`
+	}{}
+	if err := json.Unmarshal(bodyBytes, &login); err == nil && login.State != 0 {
+		return "", "", &LoginError{Site: p.site, User: p.username, Err: fmt.Errorf("login rejected with state %d", login.State)}
 	}
-	defer resp.Body.Close()
 
 	jsessionId := ""
 	for _, cookie := range resp.Cookies() {
@@ -69,23 +105,87 @@ func (p *GinlongProvider) GetSolarStatus() (*models.SolarStatus, error) {
 		}
 	}
 	if jsessionId == "" {
-		return nil, fmt.Errorf("Could not find JSESSIONID in response.")
+		return "", "", &LoginError{Site: p.site, User: p.username, Err: fmt.Errorf("Could not find JSESSIONID in response.")}
 	}
+	return jsessionId, login.Result.UserID.String(), nil
+}
 
-	params = url.Values{}
-	params.Add("plantId", p.pid)
-	body = strings.NewReader(params.Encode())
+// ginlongPageSize is the number of plants requested per page.
+const ginlongPageSize = 100
+
+// Plants lists the plants of the account, fetching the list page by page.
+func (p *GinlongProvider) Plants() ([]GinlongPlant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
+	defer cancel()
 
-	req, err = http.NewRequestWithContext(ctx, "POST", "https://m.ginlong.com/cpro/epc/plantDetail/showPlantDetailAjax.json", body)
+	jsessionId, userId, err := p.login(ctx)
 	if err != nil {
-		// handle err
+		return nil, err
 	}
-	req.Header.Set("Cookie", fmt.Sprintf("JSESSIONID=%s", jsessionId))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err = http.DefaultClient.Do(req)
+	var plants []GinlongPlant
+	for page := 1; ; page++ {
+		data, err := p.plantPage(ctx, jsessionId, userId, page)
+		if err != nil {
+			return nil, err
+		}
+		plants = append(plants, data...)
+		if len(data) < ginlongPageSize {
+			return plants, nil
+		}
+	}
+}
+
+func (p *GinlongProvider) plantPage(ctx context.Context, jsessionId, userId string, page int) ([]GinlongPlant, error) {
+	params := neturl.Values{}
+	params.Add("uid", userId)
+	params.Add("pageNum", fmt.Sprintf("%d", page))
+	params.Add("pageSize", fmt.Sprintf("%d", ginlongPageSize))
+	resp, err := p.post(ctx, "https://m.ginlong.com/cpro/epc/plantview/view/doPlantList.json", jsessionId, params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body from request: %s", err)
+	}
+	plantList := struct {
+		Result struct {
+			Pagination struct {
+				Data []struct {
+					PlantID   json.Number `json:"plantId"`
+					PlantName string      `json:"plantName"`
+				} `json:"data"`
+			} `json:"pagination"`
+		} `json:"result"`
+	}{}
+	if err := json.Unmarshal(bodyBytes, &plantList); err != nil {
+		return nil, fmt.Errorf("failed to parse body to json: %s", err)
+	}
+
+	var plants []GinlongPlant
+	for _, d := range plantList.Result.Pagination.Data {
+		plants = append(plants, GinlongPlant{ID: d.PlantID.String(), Name: d.PlantName})
+	}
+	return plants, nil
+}
+
+func (p *GinlongProvider) GetSolarStatus() (*models.SolarStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
+	defer cancel()
+
+	jsessionId, _, err := p.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	params := neturl.Values{}
+	params.Add("plantId", p.pid)
+	resp, err := p.post(ctx, "https://m.ginlong.com/cpro/epc/plantDetail/showPlantDetailAjax.json", jsessionId, params)
 	if err != nil {
-		// handle err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -147,23 +247,23 @@ func (p *GinlongProvider) GetSolarStatus() (*models.SolarStatus, error) {
 					UpdateDate int64   `json:"updateDate"`
 				} `json:"plant"`
 				PlantData struct {
-					BatterySoc            string  `json:"batterySoc"`
-					EnergyMonth           float64 `json:"energyMonth"`
-					EnergyToday           float64 `json:"energyToday"`
-					EnergyTotal           float64 `json:"energyTotal"`
-					EnergyTotalReal       float64 `json:"energyTotalReal"`
-					EnergyYear            float64 `json:"energyYear"`
-					HoursEnergy           float64 `json:"hoursEnergy"`
-					HoursenergyUpdatetime int64   `json:"hoursenergyUpdatetime"`
-					IncomeMonth           float64 `json:"incomeMonth"`
-					IncomeToday           float64 `json:"incomeToday"`
-					IncomeTotal           float64 `json:"incomeTotal"`
-					IncomeTotalReal       float64 `json:"incomeTotalReal"`
-					IncomeYear            float64 `json:"incomeYear"`
-					PlantID               int     `json:"plantId"`
-					PlantUpdateTime       int64   `json:"plantUpdateTime"`
-					Power                 float64 `json:"power"`
-					UpdateTime            int64   `json:"updateTime"`
+					BatterySoc            string   `json:"batterySoc"`
+					EnergyMonth           float64  `json:"energyMonth"`
+					EnergyToday           float64  `json:"energyToday"`
+					EnergyTotal           float64  `json:"energyTotal"`
+					EnergyTotalReal       float64  `json:"energyTotalReal"`
+					EnergyYear            float64  `json:"energyYear"`
+					HoursEnergy           float64  `json:"hoursEnergy"`
+					HoursenergyUpdatetime int64    `json:"hoursenergyUpdatetime"`
+					IncomeMonth           *float64 `json:"incomeMonth"`
+					IncomeToday           *float64 `json:"incomeToday"`
+					IncomeTotal           *float64 `json:"incomeTotal"`
+					IncomeTotalReal       float64  `json:"incomeTotalReal"`
+					IncomeYear            *float64 `json:"incomeYear"`
+					PlantID               int      `json:"plantId"`
+					PlantUpdateTime       int64    `json:"plantUpdateTime"`
+					Power                 float64  `json:"power"`
+					UpdateTime            int64    `json:"updateTime"`
 				} `json:"plantData"`
 				PlantDetail struct {
 					BenchmarkPrice     float64 `json:"benchmarkPrice"`
@@ -187,15 +287,15 @@ func (p *GinlongProvider) GetSolarStatus() (*models.SolarStatus, error) {
 					Years              int     `json:"years"`
 				} `json:"plantDetail"`
 			} `json:"plantAllWapper"`
-			Co2            float64 `json:"co2"`
+			Co2            *float64 `json:"co2"`
 			ParamSelectors struct {
 				ParamDaySelectors   string `json:"paramDaySelectors"`
 				ParamMonthSelectors string `json:"paramMonthSelectors"`
 				ParamYearSelectors  string `json:"paramYearSelectors"`
 				ParamAllSelectors   string `json:"paramAllSelectors"`
 			} `json:"paramSelectors"`
-			Tree float64 `json:"tree"`
-			Pic  string  `json:"pic"`
+			Tree *float64 `json:"tree"`
+			Pic  string   `json:"pic"`
 		} `json:"result"`
 		State int `json:"state"`
 	}{}
@@ -212,6 +312,27 @@ func (p *GinlongProvider) GetSolarStatus() (*models.SolarStatus, error) {
 	energyYear := d.Result.PlantAllWapper.PlantData.EnergyYear * 1000
 	energyTotal := d.Result.PlantAllWapper.PlantData.EnergyTotal * 1000
 
-	status := models.SolarStatus{EnergyToday: energyToday, EnergyMonth: energyMonth, EnergyYear: energyYear, EnergyTotal: energyTotal, PowerNow: powerNow}
+	plantData := d.Result.PlantAllWapper.PlantData
+	// Plants without a tariff report no currency or income.
+	var financial *models.FinancialStatus
+	currency := d.Result.PlantAllWapper.Plant.Currency.CurrencyCode
+	if currency != "" || plantData.IncomeToday != nil || plantData.IncomeTotal != nil {
+		financial = &models.FinancialStatus{
+			Currency:    currency,
+			IncomeToday: plantData.IncomeToday,
+			IncomeMonth: plantData.IncomeMonth,
+			IncomeYear:  plantData.IncomeYear,
+		}
+		if plantData.IncomeTotal != nil {
+			financial.IncomeTotal = *plantData.IncomeTotal
+		}
+	}
+	// The co2 is reported in kg.
+	var environmental *models.EnvironmentalStatus
+	if d.Result.Co2 != nil {
+		environmental = &models.EnvironmentalStatus{CO2Avoided: *d.Result.Co2, TreesPlanted: d.Result.Tree}
+	}
+
+	status := models.SolarStatus{EnergyToday: energyToday, EnergyMonth: energyMonth, EnergyYear: energyYear, EnergyTotal: energyTotal, PowerNow: powerNow, Financial: financial, Environmental: environmental}
 	return &status, nil
 }
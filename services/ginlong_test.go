@@ -1,7 +1,11 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -16,3 +20,124 @@ func TestNewGinlongProvider(t *testing.T) {
 	}
 	fmt.Printf("%+v", status)
 }
+
+func TestGinlongPlants(t *testing.T) {
+	provider := NewGinlongProvider("test", "user", "secret", "", 10, nil)
+	provider.SetTransport(fakeTransport(func(req *http.Request) (*http.Response, error) {
+		header := http.Header{}
+		var body string
+		switch req.URL.Path {
+		case "/cpro/login/validateLogin.json":
+			header.Set("Set-Cookie", "JSESSIONID=abc; Path=/")
+			body = `{"result":{"userId":4242},"state":0}`
+		case "/cpro/epc/plantview/view/doPlantList.json":
+			if req.Header.Get("Cookie") != "JSESSIONID=abc" {
+				t.Errorf("Expected the session cookie, got %s", req.Header.Get("Cookie"))
+			}
+			req.ParseForm()
+			if req.PostForm.Get("uid") != "4242" {
+				t.Errorf("Expected the user id, got %s", req.PostForm.Get("uid"))
+			}
+			body = `{"result":{"pagination":{"data":[{"plantId":1001,"plantName":"Roof"},{"plantId":1002,"plantName":"Shed"}]}},"state":0}`
+		}
+		return &http.Response{StatusCode: 200, Status: "200 OK", Header: header, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}))
+
+	plants, err := provider.Plants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plants) != 2 || plants[0].ID != "1001" || plants[1].Name != "Shed" {
+		t.Errorf("Unexpected plants %+v", plants)
+	}
+}
+
+func TestGinlongPlantPages(t *testing.T) {
+	provider := NewGinlongProvider("test", "user", "secret", "", 10, nil)
+	provider.SetTransport(fakeTransport(func(req *http.Request) (*http.Response, error) {
+		header := http.Header{"Set-Cookie": {"JSESSIONID=abc; Path=/"}}
+		body := `{"result":{"userId":4242},"state":0}`
+		if req.URL.Path == "/cpro/epc/plantview/view/doPlantList.json" {
+			req.ParseForm()
+			// A full first page and a partial second page.
+			count := ginlongPageSize
+			if req.PostForm.Get("pageNum") == "2" {
+				count = 5
+			}
+			var data []string
+			for i := 0; i < count; i++ {
+				data = append(data, fmt.Sprintf(`{"plantId":%s%d}`, req.PostForm.Get("pageNum"), 1000+i))
+			}
+			body = `{"result":{"pagination":{"data":[` + strings.Join(data, ",") + `]}},"state":0}`
+		}
+		return &http.Response{StatusCode: 200, Status: "200 OK", Header: header, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}))
+
+	plants, err := provider.Plants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plants) != ginlongPageSize+5 || plants[ginlongPageSize].ID != "21000" {
+		t.Errorf("Expected the plants of both pages, got %d", len(plants))
+	}
+}
+
+func TestGinlongLoginError(t *testing.T) {
+	provider := NewGinlongProvider("test", "user", "wrong", "1001", 10, nil)
+	provider.SetTransport(fakeTransport(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Status: "200 OK", Body: io.NopCloser(strings.NewReader(`{"state":1}`))}, nil
+	}))
+
+	_, err := provider.GetSolarStatus()
+	var loginErr *LoginError
+	if !errors.As(err, &loginErr) {
+		t.Errorf("Expected a LoginError, got %v", err)
+	}
+
+	// An unreachable portal is not a login error.
+	provider.SetTransport(fakeTransport(func(req *http.Request) (*http.Response, error) {
+		return nil, fmt.Errorf("connection refused")
+	}))
+	if _, err := provider.GetSolarStatus(); err == nil || errors.As(err, &loginErr) {
+		t.Errorf("Expected a plain error, got %v", err)
+	}
+}
+
+func TestGinlongFinancial(t *testing.T) {
+	provider := NewGinlongProvider("test", "user", "secret", "1001", 10, nil)
+	provider.SetTransport(fakeTransport(func(req *http.Request) (*http.Response, error) {
+		header := http.Header{"Set-Cookie": {"JSESSIONID=abc; Path=/"}}
+		body := `{"result":{"plantAllWapper":{"plant":{"currency":{"currencyCode":"EUR"}},"plantData":{"power":1200,"energyToday":5.5,"energyTotal":3400,"incomeToday":1.21,"incomeMonth":30.5,"incomeYear":250,"incomeTotal":748.2}},"co2":3390.6,"tree":185},"state":0}`
+		return &http.Response{StatusCode: 200, Status: "200 OK", Header: header, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}))
+
+	status, err := provider.GetSolarStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := status.Financial
+	if f == nil || f.Currency != "EUR" || *f.IncomeToday != 1.21 || f.IncomeTotal != 748.2 {
+		t.Errorf("Unexpected financial status %+v", f)
+	}
+	e := status.Environmental
+	if e == nil || e.CO2Avoided != 3390.6 || *e.TreesPlanted != 185 {
+		t.Errorf("Unexpected environmental status %+v", e)
+	}
+
+	// Plants without a tariff have no financial status.
+	provider.SetTransport(fakeTransport(func(req *http.Request) (*http.Response, error) {
+		header := http.Header{"Set-Cookie": {"JSESSIONID=abc; Path=/"}}
+		body := `{"result":{"plantAllWapper":{"plantData":{"power":1200,"energyToday":5.5,"energyTotal":3400}}},"state":0}`
+		return &http.Response{StatusCode: 200, Status: "200 OK", Header: header, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}))
+	status, err = provider.GetSolarStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Financial != nil {
+		t.Errorf("Expected no financial status, got %+v", status.Financial)
+	}
+	if status.Environmental != nil {
+		t.Errorf("Expected no environmental status, got %+v", status.Environmental)
+	}
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+const growattDefaultBaseURL = "https://server.growatt.com"
+
+type GrowattProvider struct {
+	site     string
+	username string
+	password string
+	pid      string
+	baseURL  string
+	timeout  int
+	db       *models.DataBase
+
+	mu       sync.Mutex
+	client   *http.Client
+	userID   string
+	loggedIn bool
+}
+
+func (p *GrowattProvider) Site() string {
+	return p.site
+}
+
+func (p *GrowattProvider) Timeout() int {
+	return p.timeout
+}
+
+func (p *GrowattProvider) DB() *models.DataBase {
+	return p.db
+}
+
+func NewGrowattProvider(site, baseURL, username, password, pid string, timeout int, db *models.DataBase) *GrowattProvider {
+	if baseURL == "" {
+		baseURL = growattDefaultBaseURL
+	}
+	jar, _ := cookiejar.New(nil)
+	return &GrowattProvider{
+		site:     site,
+		username: username,
+		password: password,
+		pid:      pid,
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		timeout:  timeout,
+		db:       db,
+		client:   &http.Client{Timeout: 30 * time.Second, Jar: jar},
+	}
+}
+
+func (p *GrowattProvider) Transport() http.RoundTripper {
+	return p.client.Transport
+}
+
+func (p *GrowattProvider) SetTransport(t http.RoundTripper) {
+	p.client.Transport = t
+}
+
+// growattHashPassword returns the password hash expected by the Growatt
+// server: the hex MD5 digest with every '0' at an even index replaced by 'c'.
+func growattHashPassword(password string) string {
+	sum := md5.Sum([]byte(password))
+	hash := []byte(hex.EncodeToString(sum[:]))
+	for i := 0; i < len(hash); i += 2 {
+		if hash[i] == '0' {
+			hash[i] = 'c'
+		}
+	}
+	return string(hash)
+}
+
+func (p *GrowattProvider) do(req *http.Request) ([]byte, error) {
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could succesfully finish request [%s]: %s", req.URL.Path, err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body from request: %s", err)
+	}
+	if res.StatusCode != 200 {
+		return nil, &statusError{code: res.StatusCode, status: res.Status}
+	}
+	return body, nil
+}
+
+func (p *GrowattProvider) login(ctx context.Context) error {
+	log.Printf("%s - Logging in to Growatt as user [%s]", p.site, p.username)
+	url := p.baseURL + "/newTwoLoginAPI.do"
+	data := neturl.Values{}
+	data.Set("userName", p.username)
+	data.Set("password", growattHashPassword(p.password))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(data.Encode()))
+	if err != nil {
+		return fmt.Errorf("could not create request for url [%s]: %s", url, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	body, err := p.do(req)
+	if unauthorized(err) {
+		return &LoginError{Site: p.site, User: p.username, Err: err}
+	}
+	if err != nil {
+		return err
+	}
+	response := struct {
+		Back struct {
+			Success bool   `json:"success"`
+			Msg     string `json:"msg"`
+			User    struct {
+				ID json.Number `json:"id"`
+			} `json:"user"`
+		} `json:"back"`
+	}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("failed to parse body to json: %s", err)
+	}
+	if !response.Back.Success {
+		return &LoginError{Site: p.site, User: p.username, Err: fmt.Errorf("%s", response.Back.Msg)}
+	}
+	p.userID = response.Back.User.ID.String()
+	p.loggedIn = true
+	log.Printf("%s - Succesfully logged in as user [%s]\n", p.site, p.username)
+	return nil
+}
+
+// get performs an authenticated GET and decodes the "back" section of the
+// response into v. The session cookie is reused between calls; when the server
+// no longer recognises it (it answers with the HTML login page instead of
+// JSON) the provider logs in again and retries once.
+func (p *GrowattProvider) get(ctx context.Context, path string, params neturl.Values, v interface{}) error {
+	for attempt := 0; attempt < 2; attempt++ {
+		if !p.loggedIn {
+			if err := p.login(ctx); err != nil {
+				return err
+			}
+		}
+
+		url := fmt.Sprintf("%s%s?%s", p.baseURL, path, params.Encode())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return fmt.Errorf("could not create request for url [%s]: %s", url, err)
+		}
+		body, err := p.do(req)
+		if err != nil {
+			return err
+		}
+
+		response := struct {
+			Back json.RawMessage `json:"back"`
+		}{}
+		if err := json.Unmarshal(body, &response); err != nil || len(response.Back) == 0 {
+			p.loggedIn = false
+			continue
+		}
+		if err := json.Unmarshal(response.Back, v); err != nil {
+			return fmt.Errorf("failed to parse body to json: %s", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("session not accepted after re-login for [%s]", path)
+}
+
+func (p *GrowattProvider) GetSolarStatus() (*models.SolarStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
+	defer cancel()
+
+	if !p.loggedIn {
+		if err := p.login(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	plantList := struct {
+		Data []struct {
+			PlantID      string `json:"plantId"`
+			PlantName    string `json:"plantName"`
+			CurrentPower string `json:"currentPower"`
+			TodayEnergy  string `json:"todayEnergy"`
+			TotalEnergy  string `json:"totalEnergy"`
+		} `json:"data"`
+	}{}
+	err := p.get(ctx, "/PlantListAPI.do", neturl.Values{"userId": {p.userID}}, &plantList)
+	if err != nil {
+		return nil, err
+	}
+	if len(plantList.Data) == 0 {
+		return nil, fmt.Errorf("no plants found for user [%s]", p.username)
+	}
+
+	plant := plantList.Data[0]
+	if p.pid != "" {
+		found := false
+		for _, d := range plantList.Data {
+			if d.PlantID == p.pid {
+				plant = d
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("plant [%s] not found for user [%s]", p.pid, p.username)
+		}
+	}
+
+	powerNow, err := convertRawToFloatWatt(plant.CurrentPower)
+	if err != nil {
+		return nil, err
+	}
+	energyToday, err := convertRawToFloatWatt(plant.TodayEnergy)
+	if err != nil {
+		return nil, err
+	}
+	energyTotal, err := convertRawToFloatWatt(plant.TotalEnergy)
+	if err != nil {
+		return nil, err
+	}
+
+	// type=2 returns the energy per month of the given year, in kWh.
+	now := time.Now()
+	plantDetail := struct {
+		PlantData struct {
+			CurrentEnergy string `json:"currentEnergy"`
+		} `json:"plantData"`
+		Data map[string]string `json:"data"`
+	}{}
+	err = p.get(ctx, "/PlantDetailAPI.do", neturl.Values{
+		"plantId": {plant.PlantID},
+		"type":    {"2"},
+		"date":    {now.Format("2006")},
+	}, &plantDetail)
+	if err != nil {
+		return nil, err
+	}
+	energyYear, err := convertRawToFloatWatt(plantDetail.PlantData.CurrentEnergy)
+	if err != nil {
+		return nil, err
+	}
+	energyMonth := 0.0
+	if raw, ok := plantDetail.Data[now.Format("01")]; ok {
+		energyMonth, err = convertRawToFloatWatt(raw + " kWh")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	status := models.SolarStatus{EnergyToday: energyToday, EnergyMonth: energyMonth, EnergyYear: energyYear, EnergyTotal: energyTotal, PowerNow: powerNow}
+	return &status, nil
+}
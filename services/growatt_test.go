@@ -0,0 +1,107 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGrowattHashPassword(t *testing.T) {
+	// md5("password") = 5f4dcc3b5aa765d61d8327deb882cf99
+	expected := "5f4dcc3b5aa765d61d8327deb882cf99"
+	if hash := growattHashPassword("password"); hash != expected {
+		t.Errorf("Expected %s, got %s", expected, hash)
+	}
+	// md5("admin") = 21232f297a57a5a743894a0e4a801fc3, only the zero at index 22 is even.
+	expected = "21232f297a57a5a743894ace4a801fc3"
+	if hash := growattHashPassword("admin"); hash != expected {
+		t.Errorf("Expected %s, got %s", expected, hash)
+	}
+}
+
+func TestGrowattGetSolarStatus(t *testing.T) {
+	logins := 0
+	month := time.Now().Format("01")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/newTwoLoginAPI.do" {
+			logins++
+			r.ParseForm()
+			if r.Form.Get("password") != growattHashPassword("secret") {
+				w.Write([]byte(`{"back":{"success":false,"msg":"501"}}`))
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: fmt.Sprintf("session-%d", logins)})
+			w.Write([]byte(`{"back":{"success":true,"user":{"id":42}}}`))
+			return
+		}
+		cookie, err := r.Cookie("JSESSIONID")
+		if err != nil || cookie.Value != fmt.Sprintf("session-%d", logins) {
+			w.Write([]byte(`<html><body>login</body></html>`))
+			return
+		}
+		switch r.URL.Path {
+		case "/PlantListAPI.do":
+			if r.URL.Query().Get("userId") != "42" {
+				t.Errorf("Expected userId 42, got %s", r.URL.Query().Get("userId"))
+			}
+			w.Write([]byte(`{"back":{"data":[{"plantId":"1","currentPower":"10 W","todayEnergy":"1 kWh","totalEnergy":"1 MWh"},{"plantId":"7","plantName":"Home","currentPower":"1.5 kW","todayEnergy":"12.3 kWh","totalEnergy":"4.5 MWh"}]}}`))
+		case "/PlantDetailAPI.do":
+			if r.URL.Query().Get("plantId") != "7" {
+				t.Errorf("Expected plantId 7, got %s", r.URL.Query().Get("plantId"))
+			}
+			w.Write([]byte(fmt.Sprintf(`{"back":{"plantData":{"currentEnergy":"850.5 kWh"},"data":{"%s":"210.5"}}}`, month)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider := NewGrowattProvider("Site", server.URL, "user", "secret", "7", 10, nil)
+	status, err := provider.GetSolarStatus()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.PowerNow != 1500 {
+		t.Errorf("Expected PowerNow 1500, got %f", status.PowerNow)
+	}
+	if status.EnergyToday != 12300 {
+		t.Errorf("Expected EnergyToday 12300, got %f", status.EnergyToday)
+	}
+	if status.EnergyMonth != 210500 {
+		t.Errorf("Expected EnergyMonth 210500, got %f", status.EnergyMonth)
+	}
+	if status.EnergyYear != 850500 {
+		t.Errorf("Expected EnergyYear 850500, got %f", status.EnergyYear)
+	}
+	if status.EnergyTotal != 4500000 {
+		t.Errorf("Expected EnergyTotal 4500000, got %f", status.EnergyTotal)
+	}
+
+	// The session cookie is reused for the next poll.
+	if _, err := provider.GetSolarStatus(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if logins != 1 {
+		t.Errorf("Expected 1 login, got %d", logins)
+	}
+
+	// An expired session triggers exactly one new login.
+	logins++
+	if _, err := provider.GetSolarStatus(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if logins != 3 {
+		t.Errorf("Expected a re-login, got %d logins", logins)
+	}
+
+	// A rejected password is a login error.
+	provider = NewGrowattProvider("Site", server.URL, "user", "wrong", "7", 10, nil)
+	_, err = provider.GetSolarStatus()
+	var loginErr *LoginError
+	if !errors.As(err, &loginErr) {
+		t.Errorf("Expected a login error, got %v", err)
+	}
+}
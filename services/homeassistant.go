@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+// homeAssistantUnits converts the unit_of_measurement of an entity to the W,
+// Wh and percent the exporter uses.
+var homeAssistantUnits = map[string]float64{
+	"%":   1,
+	"W":   1,
+	"kW":  1000,
+	"MW":  1000000,
+	"Wh":  1,
+	"kWh": 1000,
+	"MWh": 1000000,
+}
+
+// HomeAssistantConfig defines a homeassistant site. Entities maps SolarStatus
+// fields such as power_now onto entity ids.
+type HomeAssistantConfig struct {
+	Site     string            `yaml:"site"`
+	Timeout  int               `yaml:"timeout"`
+	BaseURL  string            `yaml:"base_url"`
+	Token    string            `yaml:"token"`
+	Entities map[string]string `yaml:"entities"`
+}
+
+// HomeAssistantProvider reads entity states from the Home Assistant REST API
+// using a long-lived access token.
+type HomeAssistantProvider struct {
+	site    string
+	config  HomeAssistantConfig
+	timeout int
+	db      *models.DataBase
+	client  *http.Client
+}
+
+func (p *HomeAssistantProvider) Site() string {
+	return p.site
+}
+
+func (p *HomeAssistantProvider) Timeout() int {
+	return p.timeout
+}
+
+func (p *HomeAssistantProvider) DB() *models.DataBase {
+	return p.db
+}
+
+func NewHomeAssistantProvider(site string, config HomeAssistantConfig, timeout int, db *models.DataBase) (*HomeAssistantProvider, error) {
+	if config.BaseURL == "" || config.Token == "" {
+		return nil, fmt.Errorf("%s - base_url and token are required", site)
+	}
+	if len(config.Entities) == 0 {
+		return nil, fmt.Errorf("%s - at least one entity is required", site)
+	}
+	for name := range config.Entities {
+		if !isSolarStatusField(name) {
+			return nil, fmt.Errorf("%s - unknown field [%s], expected one of %s", site, name, strings.Join(solarStatusFields, ", "))
+		}
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &HomeAssistantProvider{site: site, config: config, timeout: timeout, db: db, client: &http.Client{}}, nil
+}
+
+func (p *HomeAssistantProvider) Transport() http.RoundTripper {
+	return p.client.Transport
+}
+
+func (p *HomeAssistantProvider) SetTransport(t http.RoundTripper) {
+	p.client.Transport = t
+}
+
+type homeAssistantState struct {
+	EntityID   string `json:"entity_id"`
+	State      string `json:"state"`
+	Attributes struct {
+		UnitOfMeasurement string `json:"unit_of_measurement"`
+	} `json:"attributes"`
+}
+
+// Value returns the state converted to W or Wh.
+func (s homeAssistantState) Value() (float64, error) {
+	value, err := strconv.ParseFloat(s.State, 64)
+	if err != nil {
+		return 0, fmt.Errorf("entity [%s] has no numeric state: %s", s.EntityID, s.State)
+	}
+	unit := s.Attributes.UnitOfMeasurement
+	if unit == "" {
+		return value, nil
+	}
+	multiplier, ok := homeAssistantUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("entity [%s] has unsupported unit [%s]", s.EntityID, unit)
+	}
+	return value * multiplier, nil
+}
+
+func (p *HomeAssistantProvider) GetSolarStatus() (*models.SolarStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
+	defer cancel()
+
+	// A single call for all states is cheaper than one call per entity.
+	url := p.config.BaseURL + "/api/states"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request for url [%s]: %s", url, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.config.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could succesfully finish request [%s]: %s", url, err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body from request: %s", err)
+	}
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		return nil, &LoginError{Site: p.site, Err: fmt.Errorf("token rejected: %d %s", res.StatusCode, res.Status)}
+	}
+	if res.StatusCode != 200 {
+		return nil, &statusError{code: res.StatusCode, status: res.Status}
+	}
+
+	states := []homeAssistantState{}
+	if err := json.Unmarshal(body, &states); err != nil {
+		return nil, fmt.Errorf("failed to parse body to json: %s", err)
+	}
+	byID := map[string]homeAssistantState{}
+	for _, s := range states {
+		byID[s.EntityID] = s
+	}
+
+	values := map[string]float64{}
+	for name, entity := range p.config.Entities {
+		s, ok := byID[entity]
+		if !ok {
+			return nil, fmt.Errorf("entity [%s] not found", entity)
+		}
+		v, err := s.Value()
+		if err != nil {
+			return nil, err
+		}
+		values[name] = v
+	}
+	return solarStatusFromFields(values), nil
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHomeAssistantGetSolarStatus(t *testing.T) {
+	state := "1.25"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/states" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`[
+			{"entity_id":"sensor.inverter_power","state":"` + state + `","attributes":{"unit_of_measurement":"kW"}},
+			{"entity_id":"sensor.inverter_energy_today","state":"850","attributes":{"unit_of_measurement":"Wh"}},
+			{"entity_id":"sensor.inverter_energy_total","state":"12.5","attributes":{"unit_of_measurement":"MWh"}},
+			{"entity_id":"light.kitchen","state":"on","attributes":{}}
+		]`))
+	}))
+	defer server.Close()
+
+	config := HomeAssistantConfig{
+		BaseURL: server.URL + "/",
+		Token:   "secret",
+		Entities: map[string]string{
+			"power_now":    "sensor.inverter_power",
+			"energy_today": "sensor.inverter_energy_today",
+			"energy_total": "sensor.inverter_energy_total",
+		},
+	}
+	provider, err := NewHomeAssistantProvider("Site", config, 10, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	status, err := provider.GetSolarStatus()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.PowerNow != 1250 {
+		t.Errorf("Expected PowerNow 1250, got %f", status.PowerNow)
+	}
+	if status.EnergyToday != 850 {
+		t.Errorf("Expected EnergyToday 850, got %f", status.EnergyToday)
+	}
+	if status.EnergyTotal != 12500000 {
+		t.Errorf("Expected EnergyTotal 12500000, got %f", status.EnergyTotal)
+	}
+
+	state = "unavailable"
+	if _, err := provider.GetSolarStatus(); err == nil {
+		t.Errorf("Expected error for unavailable entity")
+	}
+
+	// A rejected token is a login error.
+	config.Token = "wrong"
+	provider, _ = NewHomeAssistantProvider("Site", config, 10, nil)
+	_, err = provider.GetSolarStatus()
+	var loginErr *LoginError
+	if !errors.As(err, &loginErr) {
+		t.Errorf("Expected a login error, got %v", err)
+	}
+}
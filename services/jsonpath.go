@@ -0,0 +1,167 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep is a single step of a JSONPath expression: a member name, an
+// array index or a wildcard.
+type jsonPathStep struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// jsonPath is a compiled JSONPath expression. Only the subset needed to pick
+// values out of vendor responses is supported: member access ($.a.b or
+// $['a']), array indices ($.a[0], $.a[-1]) and wildcards ($.a[*], $.a.*).
+type jsonPath struct {
+	expr  string
+	steps []jsonPathStep
+}
+
+func compileJSONPath(expr string) (*jsonPath, error) {
+	rest := strings.TrimSpace(expr)
+	if !strings.HasPrefix(rest, "$") {
+		return nil, fmt.Errorf("invalid JSONPath [%s]: must start with $", expr)
+	}
+	rest = rest[1:]
+
+	p := &jsonPath{expr: expr}
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" {
+				return nil, fmt.Errorf("invalid JSONPath [%s]: empty member name", expr)
+			}
+			if name == "*" {
+				p.steps = append(p.steps, jsonPathStep{wildcard: true})
+			} else {
+				p.steps = append(p.steps, jsonPathStep{name: name})
+			}
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("invalid JSONPath [%s]: unclosed bracket", expr)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				p.steps = append(p.steps, jsonPathStep{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				p.steps = append(p.steps, jsonPathStep{name: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid JSONPath [%s]: bad index [%s]", expr, inner)
+				}
+				p.steps = append(p.steps, jsonPathStep{index: index, isIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("invalid JSONPath [%s]: unexpected [%c]", expr, rest[0])
+		}
+	}
+	return p, nil
+}
+
+// Find returns all values the expression selects in a decoded JSON document.
+func (p *jsonPath) Find(doc interface{}) []interface{} {
+	current := []interface{}{doc}
+	for _, step := range p.steps {
+		var next []interface{}
+		for _, v := range current {
+			switch node := v.(type) {
+			case map[string]interface{}:
+				if step.wildcard {
+					for _, child := range node {
+						next = append(next, child)
+					}
+				} else if child, ok := node[step.name]; ok && !step.isIndex {
+					next = append(next, child)
+				}
+			case []interface{}:
+				if step.wildcard {
+					next = append(next, node...)
+				} else if step.isIndex {
+					i := step.index
+					if i < 0 {
+						i += len(node)
+					}
+					if i >= 0 && i < len(node) {
+						next = append(next, node[i])
+					}
+				}
+			}
+		}
+		current = next
+	}
+	return current
+}
+
+// Float returns the sum of the numeric values the expression selects, so that
+// a wildcard over several inverters adds up their readings. Numeric strings
+// and booleans are accepted as well.
+func (p *jsonPath) Float(doc interface{}) (float64, error) {
+	values := p.Find(doc)
+	if len(values) == 0 {
+		return 0, fmt.Errorf("JSONPath [%s] matched nothing", p.expr)
+	}
+	total := 0.0
+	for _, v := range values {
+		f, err := jsonFloat(v)
+		if err != nil {
+			return 0, fmt.Errorf("JSONPath [%s]: %s", p.expr, err)
+		}
+		total += f
+	}
+	return total, nil
+}
+
+// String returns the first value the expression selects as a string.
+func (p *jsonPath) String(doc interface{}) (string, error) {
+	values := p.Find(doc)
+	if len(values) == 0 {
+		return "", fmt.Errorf("JSONPath [%s] matched nothing", p.expr)
+	}
+	switch v := values[0].(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+}
+
+func jsonFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil {
+			return 0, fmt.Errorf("could not convert [%s] to float: %s", n, err)
+		}
+		return f, nil
+	case bool:
+		if n {
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("value [%v] is not a number", v)
+	}
+}
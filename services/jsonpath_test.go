@@ -0,0 +1,48 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	var doc interface{}
+	err := json.Unmarshal([]byte(`{"data":{"pac":"1.5","inverters":[{"p":100},{"p":250.5}],"odd key":{"v":true}}}`), &doc)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		expr     string
+		expected float64
+	}{
+		{"$.data.pac", 1.5},
+		{"$.data.inverters[1].p", 250.5},
+		{"$.data.inverters[-1].p", 250.5},
+		{"$.data.inverters[*].p", 350.5},
+		{"$['data']['odd key'].v", 1},
+	}
+	for _, test := range tests {
+		path, err := compileJSONPath(test.expr)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", test.expr, err)
+		}
+		value, err := path.Float(doc)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", test.expr, err)
+		}
+		if value != test.expected {
+			t.Errorf("Expected %s to be %f, got %f", test.expr, test.expected, value)
+		}
+	}
+
+	path, _ := compileJSONPath("$.data.missing")
+	if _, err := path.Float(doc); err == nil {
+		t.Errorf("Expected error for missing value")
+	}
+	for _, expr := range []string{"data.pac", "$.data[", "$.data[x]", "$..pac"} {
+		if _, err := compileJSONPath(expr); err == nil {
+			t.Errorf("Expected error for %s", expr)
+		}
+	}
+}
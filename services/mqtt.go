@@ -0,0 +1,196 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rvben/solar_exporter/models"
+)
+
+// MQTTField maps a value published on an MQTT topic onto a SolarStatus field.
+// Path is a JSONPath into a JSON payload; leave it empty for topics that carry
+// a plain number, such as ESPHome state topics.
+type MQTTField struct {
+	Topic      string  `yaml:"topic"`
+	Path       string  `yaml:"path"`
+	Multiplier float64 `yaml:"multiplier"`
+}
+
+// MQTTConfig defines an mqtt site.
+type MQTTConfig struct {
+	Site       string               `yaml:"site"`
+	Timeout    int                  `yaml:"timeout"`
+	Broker     string               `yaml:"broker"`
+	ClientID   string               `yaml:"client_id"`
+	Username   string               `yaml:"username"`
+	Password   string               `yaml:"password"`
+	StaleAfter int                  `yaml:"stale_after"`
+	Fields     map[string]MQTTField `yaml:"fields"`
+}
+
+type mqttValue struct {
+	value    float64
+	received time.Time
+}
+
+// MQTTProvider subscribes to the topics of its fields and returns the latest
+// value of each from GetSolarStatus.
+type MQTTProvider struct {
+	site       string
+	config     MQTTConfig
+	timeout    int
+	staleAfter time.Duration
+	db         *models.DataBase
+
+	mu     sync.Mutex
+	client mqtt.Client
+	paths  map[string]*jsonPath
+	values map[string]mqttValue
+}
+
+func (p *MQTTProvider) Site() string {
+	return p.site
+}
+
+func (p *MQTTProvider) Timeout() int {
+	return p.timeout
+}
+
+func (p *MQTTProvider) DB() *models.DataBase {
+	return p.db
+}
+
+func NewMQTTProvider(site string, config MQTTConfig, timeout int, db *models.DataBase) (*MQTTProvider, error) {
+	if config.Broker == "" {
+		return nil, fmt.Errorf("%s - broker is required", site)
+	}
+	if len(config.Fields) == 0 {
+		return nil, fmt.Errorf("%s - at least one field is required", site)
+	}
+	paths := map[string]*jsonPath{}
+	for name, f := range config.Fields {
+		if !isSolarStatusField(name) {
+			return nil, fmt.Errorf("%s - unknown field [%s], expected one of %s", site, name, strings.Join(solarStatusFields, ", "))
+		}
+		if f.Topic == "" {
+			return nil, fmt.Errorf("%s - field [%s] has no topic", site, name)
+		}
+		if f.Path != "" {
+			path, err := compileJSONPath(f.Path)
+			if err != nil {
+				return nil, fmt.Errorf("%s - %s", site, err)
+			}
+			paths[name] = path
+		}
+	}
+
+	// Values older than a few polls mean the publisher is gone.
+	staleAfter := time.Duration(config.StaleAfter) * time.Second
+	if staleAfter == 0 {
+		staleAfter = 3 * time.Duration(timeout) * time.Second
+	}
+	return &MQTTProvider{site: site, config: config, timeout: timeout, staleAfter: staleAfter, db: db, paths: paths, values: map[string]mqttValue{}}, nil
+}
+
+// Connect connects to the broker in the background. Subscriptions are renewed
+// on every (re)connect.
+func (p *MQTTProvider) Connect() {
+	clientID := p.config.ClientID
+	if clientID == "" {
+		clientID = "solar_exporter_" + p.site
+	}
+	topics := map[string]byte{}
+	for _, f := range p.config.Fields {
+		topics[f.Topic] = 0
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(p.config.Broker).
+		SetClientID(clientID).
+		SetUsername(p.config.Username).
+		SetPassword(p.config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			log.Printf("%s - Connected to MQTT broker [%s]", p.site, p.config.Broker)
+			token := c.SubscribeMultiple(topics, func(_ mqtt.Client, m mqtt.Message) {
+				p.handle(m.Topic(), m.Payload())
+			})
+			if token.Wait() && token.Error() != nil {
+				log.Printf("%s - Could not subscribe: %s", p.site, token.Error())
+			}
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("%s - Lost connection to MQTT broker [%s]: %s", p.site, p.config.Broker, err)
+		})
+
+	p.client = mqtt.NewClient(opts)
+	p.client.Connect()
+}
+
+// Disconnect closes the connection to the broker.
+func (p *MQTTProvider) Disconnect() {
+	if p.client != nil {
+		p.client.Disconnect(250)
+	}
+}
+
+func (p *MQTTProvider) handle(topic string, payload []byte) {
+	var doc interface{}
+	parsed := false
+
+	for name, f := range p.config.Fields {
+		if f.Topic != topic {
+			continue
+		}
+		var value float64
+		var err error
+		if path, ok := p.paths[name]; ok {
+			if !parsed {
+				if err := json.Unmarshal(payload, &doc); err != nil {
+					log.Printf("%s - Discarding message on [%s]: %s", p.site, topic, err)
+					return
+				}
+				parsed = true
+			}
+			value, err = path.Float(doc)
+		} else {
+			value, err = strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+		}
+		if err != nil {
+			log.Printf("%s - Could not read field [%s] from [%s]: %s", p.site, name, topic, err)
+			continue
+		}
+		if f.Multiplier != 0 {
+			value *= f.Multiplier
+		}
+
+		p.mu.Lock()
+		p.values[name] = mqttValue{value: value, received: time.Now()}
+		p.mu.Unlock()
+	}
+}
+
+func (p *MQTTProvider) GetSolarStatus() (*models.SolarStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	values := map[string]float64{}
+	for name, f := range p.config.Fields {
+		v, ok := p.values[name]
+		if !ok {
+			return nil, fmt.Errorf("no value received for field [%s] on [%s]", name, f.Topic)
+		}
+		if time.Since(v.received) > p.staleAfter {
+			return nil, fmt.Errorf("no value received for field [%s] on [%s] since %s", name, f.Topic, v.received.Format(time.RFC3339))
+		}
+		values[name] = v.value
+	}
+	return solarStatusFromFields(values), nil
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+func startTestBroker(t *testing.T) (*mochi.Server, string) {
+	server := mochi.New(&mochi.Options{InlineClient: true})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatalf("Error adding hook: %v", err)
+	}
+	tcp := listeners.NewTCP(listeners.Config{ID: "test", Address: "127.0.0.1:0"})
+	if err := server.AddListener(tcp); err != nil {
+		t.Fatalf("Error adding listener: %v", err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+	return server, "tcp://" + tcp.Address()
+}
+
+func TestMQTTProvider(t *testing.T) {
+	broker, address := startTestBroker(t)
+
+	config := MQTTConfig{
+		Broker: address,
+		Fields: map[string]MQTTField{
+			"power_now":    {Topic: "tele/inverter/SENSOR", Path: "$.ENERGY.Power"},
+			"energy_today": {Topic: "tele/inverter/SENSOR", Path: "$.ENERGY.Today", Multiplier: 1000},
+			"energy_total": {Topic: "inverter/sensor/total/state", Multiplier: 1000},
+		},
+	}
+	provider, err := NewMQTTProvider("Site", config, 10, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	provider.Connect()
+	defer provider.Disconnect()
+
+	if _, err := provider.GetSolarStatus(); err == nil {
+		t.Errorf("Expected error before any message was received")
+	}
+
+	// Publish until the subscription is in place and both topics have arrived.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		broker.Publish("tele/inverter/SENSOR", []byte(`{"Time":"2024-06-01T12:00:00","ENERGY":{"Power":1234,"Today":5.5}}`), false, 0)
+		broker.Publish("inverter/sensor/total/state", []byte("4321.5"), false, 0)
+		time.Sleep(50 * time.Millisecond)
+		if _, err := provider.GetSolarStatus(); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+
+	status, err := provider.GetSolarStatus()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.PowerNow != 1234 {
+		t.Errorf("Expected PowerNow 1234, got %f", status.PowerNow)
+	}
+	if status.EnergyToday != 5500 {
+		t.Errorf("Expected EnergyToday 5500, got %f", status.EnergyToday)
+	}
+	if status.EnergyTotal != 4321500 {
+		t.Errorf("Expected EnergyTotal 4321500, got %f", status.EnergyTotal)
+	}
+
+	// Values that are not refreshed go stale.
+	provider.staleAfter = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	if _, err := provider.GetSolarStatus(); err == nil {
+		t.Errorf("Expected error for stale values")
+	}
+}
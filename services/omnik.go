@@ -20,6 +20,7 @@ type OmnikProvider struct {
 	site     string
 	timeout  int
 	db       *models.DataBase
+	client   *http.Client
 }
 
 func (p *OmnikProvider) Site() string {
@@ -35,22 +36,29 @@ func (p *OmnikProvider) DB() *models.DataBase {
 }
 
 func NewOmnikProvider(site, base_url, pid string, timeout int, db *models.DataBase) *OmnikProvider {
-	return &OmnikProvider{site: site, pid: pid, base_url: base_url, timeout: timeout, db: db}
-}
-
-func (p *OmnikProvider) GetSolarStatus() (*models.SolarStatus, error) {
 	transport := http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
 	client := &http.Client{
 		Timeout:   60 * time.Second,
 		Transport: &transport,
 	}
+	return &OmnikProvider{site: site, pid: pid, base_url: base_url, timeout: timeout, db: db, client: client}
+}
+
+func (p *OmnikProvider) Transport() http.RoundTripper {
+	return p.client.Transport
+}
+
+func (p *OmnikProvider) SetTransport(t http.RoundTripper) {
+	p.client.Transport = t
+}
 
+func (p *OmnikProvider) GetSolarStatus() (*models.SolarStatus, error) {
 	url := fmt.Sprintf("%s/Terminal/TerminalMain.aspx?pid=%s", p.base_url, p.pid)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("could not create request for url [%s]: %s", url, err)
 	}
-	res, err := client.Do(req)
+	res, err := p.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("could succesfully finish request [%s]: %s", url, err)
 	}
@@ -68,7 +76,7 @@ func (p *OmnikProvider) GetSolarStatus() (*models.SolarStatus, error) {
 		}
 	}
 
-	res, err = client.Do(req)
+	res, err = p.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("could succesfully finish request [%s]: %s", url, err)
 	}
@@ -127,9 +135,41 @@ func (p *OmnikProvider) GetSolarStatus() (*models.SolarStatus, error) {
 	}
 
 	status := models.SolarStatus{EnergyToday: energyToday, EnergyMonth: energyMonth, EnergyYear: energyYear, EnergyTotal: energyTotal, PowerNow: powerNow}
+	if income, currency, err := splitAmount(d.Income); err == nil {
+		status.Financial = &models.FinancialStatus{Currency: currency, IncomeTotal: income}
+	}
+	if co2, unit, err := splitAmount(d.Co2); err == nil {
+		// Large amounts are given in tonnes.
+		if strings.HasPrefix(strings.ToLower(unit), "t") {
+			co2 *= 1000
+		}
+		status.Environmental = &models.EnvironmentalStatus{CO2Avoided: co2}
+		if trees, _, err := splitAmount(d.Treesplanted); err == nil {
+			status.Environmental.TreesPlanted = &trees
+		}
+	}
 	return &status, nil
 }
 
+// splitAmount splits a formatted amount such as "€ 1,234.50", "1234.5 EUR" or
+// "3.2 t" into its value and the text around it, like a currency or unit.
+func splitAmount(raw string) (float64, string, error) {
+	start := strings.IndexAny(raw, "0123456789")
+	if start < 0 {
+		return 0, "", fmt.Errorf("no amount in [%s]", raw)
+	}
+	end := start
+	for end < len(raw) && strings.ContainsRune("0123456789.,", rune(raw[end])) {
+		end++
+	}
+	value, err := strconv.ParseFloat(strings.ReplaceAll(raw[start:end], ",", ""), 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("could not convert [%s] to float: %s", raw, err)
+	}
+	unit := strings.TrimSpace(raw[:start] + raw[end:])
+	return value, unit, nil
+}
+
 func convertRawToFloatWatt(raw string) (float64, error) {
 	var multiplier float64
 	var valueString string
@@ -140,9 +180,21 @@ func convertRawToFloatWatt(raw string) (float64, error) {
 	} else if strings.Contains(raw, "MWh") {
 		valueString = strings.Replace(raw, " MWh", "", -1)
 		multiplier = 1000000
+	} else if strings.Contains(raw, "GWh") {
+		valueString = strings.Replace(raw, " GWh", "", -1)
+		multiplier = 1000000000
 	} else if strings.Contains(raw, "kW") {
 		valueString = strings.Replace(raw, " kW", "", -1)
 		multiplier = 1000
+	} else if strings.Contains(raw, "MW") {
+		valueString = strings.Replace(raw, " MW", "", -1)
+		multiplier = 1000000
+	} else if strings.Contains(raw, "Wh") {
+		valueString = strings.Replace(raw, " Wh", "", -1)
+		multiplier = 1
+	} else if strings.Contains(raw, "W") {
+		valueString = strings.Replace(raw, " W", "", -1)
+		multiplier = 1
 	}
 
 	value, err := strconv.ParseFloat(valueString, 64)
@@ -0,0 +1,52 @@
+package services
+
+import "testing"
+
+func TestSplitAmount(t *testing.T) {
+	tests := []struct {
+		raw   string
+		value float64
+		unit  string
+	}{
+		{"€ 1,234.50", 1234.5, "€"},
+		{"1234.5 EUR", 1234.5, "EUR"},
+		{"3.2 t", 3.2, "t"},
+		{"17", 17, ""},
+	}
+	for _, test := range tests {
+		value, unit, err := splitAmount(test.raw)
+		if err != nil {
+			t.Errorf("Unexpected error for [%s]: %v", test.raw, err)
+		}
+		if value != test.value || unit != test.unit {
+			t.Errorf("Expected %f %q for [%s], got %f %q", test.value, test.unit, test.raw, value, unit)
+		}
+	}
+	if _, _, err := splitAmount("n/a"); err == nil {
+		t.Errorf("Expected an error without an amount")
+	}
+}
+
+func TestConvertRawToFloatWatt(t *testing.T) {
+	tests := []struct {
+		raw   string
+		value float64
+	}{
+		{"350 W", 350},
+		{"1.5 kW", 1500},
+		{"1.2 MW", 1200000},
+		{"800 Wh", 800},
+		{"12.3 kWh", 12300},
+		{"4.5 MWh", 4500000},
+		{"2.25 GWh", 2250000000},
+	}
+	for _, test := range tests {
+		value, err := convertRawToFloatWatt(test.raw)
+		if err != nil {
+			t.Errorf("Unexpected error for [%s]: %v", test.raw, err)
+		}
+		if value != test.value {
+			t.Errorf("Expected %f for [%s], got %f", test.value, test.raw, value)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+// openDTUValue is a single reading as published by OpenDTU, e.g.
+// {"v": 12.3, "u": "kWh", "d": 3}.
+type openDTUValue struct {
+	V float64 `json:"v"`
+	U string  `json:"u"`
+}
+
+// Value returns the reading in W or Wh, or 0 when it was not reported.
+func (v *openDTUValue) Value() float64 {
+	if v == nil {
+		return 0
+	}
+	if v.U == "kW" || v.U == "kWh" {
+		return v.V * 1000
+	}
+	return v.V
+}
+
+// Optional returns the reading in W or Wh, or nil when it was not reported.
+func (v *openDTUValue) Optional() *float64 {
+	if v == nil {
+		return nil
+	}
+	value := v.Value()
+	return &value
+}
+
+// openDTUChannel is an AC or DC channel of an inverter. Readings the channel
+// does not report are nil.
+type openDTUChannel struct {
+	Name struct {
+		U string `json:"u"`
+	} `json:"name"`
+	Power      *openDTUValue `json:"Power"`
+	Voltage    *openDTUValue `json:"Voltage"`
+	Current    *openDTUValue `json:"Current"`
+	YieldDay   *openDTUValue `json:"YieldDay"`
+	YieldTotal *openDTUValue `json:"YieldTotal"`
+}
+
+type openDTUInverter struct {
+	Serial string                    `json:"serial"`
+	Name   string                    `json:"name"`
+	AC     map[string]openDTUChannel `json:"AC"`
+	DC     map[string]openDTUChannel `json:"DC"`
+}
+
+type openDTUStatus struct {
+	Inverters []openDTUInverter `json:"inverters"`
+	Total     struct {
+		Power      openDTUValue `json:"Power"`
+		YieldDay   openDTUValue `json:"YieldDay"`
+		YieldTotal openDTUValue `json:"YieldTotal"`
+	} `json:"total"`
+}
+
+// OpenDTUProvider reads the live data of Hoymiles microinverters from the
+// REST API of an OpenDTU gateway. AhoyDTU gateways have a different API, read
+// by AhoyDTUProvider.
+type OpenDTUProvider struct {
+	site     string
+	baseURL  string
+	username string
+	password string
+	timeout  int
+	db       *models.DataBase
+	client   *http.Client
+
+	mu        sync.Mutex
+	inverters []models.InverterStatus
+}
+
+func (p *OpenDTUProvider) Site() string {
+	return p.site
+}
+
+func (p *OpenDTUProvider) Timeout() int {
+	return p.timeout
+}
+
+func (p *OpenDTUProvider) DB() *models.DataBase {
+	return p.db
+}
+
+func NewOpenDTUProvider(site, baseURL, username, password string, timeout int, db *models.DataBase) *OpenDTUProvider {
+	return &OpenDTUProvider{site: site, baseURL: strings.TrimRight(baseURL, "/"), username: username, password: password, timeout: timeout, db: db, client: &http.Client{}}
+}
+
+func (p *OpenDTUProvider) Transport() http.RoundTripper {
+	return p.client.Transport
+}
+
+func (p *OpenDTUProvider) SetTransport(t http.RoundTripper) {
+	p.client.Transport = t
+}
+
+func (p *OpenDTUProvider) liveData(ctx context.Context, serial string) (*openDTUStatus, error) {
+	url := p.baseURL + "/api/livedata/status"
+	if serial != "" {
+		url += "?inv=" + neturl.QueryEscape(serial)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request for url [%s]: %s", url, err)
+	}
+	if p.username != "" {
+		req.SetBasicAuth(p.username, p.password)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could succesfully finish request [%s]: %s", url, err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body from request: %s", err)
+	}
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		return nil, &LoginError{Site: p.site, User: p.username, Err: &statusError{code: res.StatusCode, status: res.Status}}
+	}
+	if res.StatusCode != 200 {
+		return nil, &statusError{code: res.StatusCode, status: res.Status}
+	}
+
+	status := &openDTUStatus{}
+	if err := json.Unmarshal(body, status); err != nil {
+		return nil, fmt.Errorf("failed to parse body to json: %s", err)
+	}
+	return status, nil
+}
+
+func (p *OpenDTUProvider) GetSolarStatus() (*models.SolarStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeout)*time.Second)
+	defer cancel()
+
+	d, err := p.liveData(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var inverters []models.InverterStatus
+	for _, inv := range d.Inverters {
+		// Since v24.2 OpenDTU only lists a summary per inverter; the channel
+		// data has to be requested for each inverter separately.
+		if inv.AC == nil {
+			detail, err := p.liveData(ctx, inv.Serial)
+			if err != nil {
+				return nil, err
+			}
+			for _, i := range detail.Inverters {
+				if i.Serial == inv.Serial {
+					inv = i
+				}
+			}
+		}
+
+		inverter := models.InverterStatus{Serial: inv.Serial, Name: inv.Name}
+		if len(inv.AC) > 0 {
+			power, energyToday, energyTotal := 0.0, 0.0, 0.0
+			for _, ac := range inv.AC {
+				power += ac.Power.Value()
+				energyToday += ac.YieldDay.Value()
+				energyTotal += ac.YieldTotal.Value()
+			}
+			inverter.PowerNow, inverter.EnergyToday, inverter.EnergyTotal = &power, &energyToday, &energyTotal
+		}
+		channels := make([]string, 0, len(inv.DC))
+		for ch := range inv.DC {
+			channels = append(channels, ch)
+		}
+		sort.Strings(channels)
+		for _, ch := range channels {
+			dc := inv.DC[ch]
+			name := dc.Name.U
+			if name == "" {
+				name = ch
+			}
+			inverter.Strings = append(inverter.Strings, models.StringStatus{
+				Name:        name,
+				PowerNow:    dc.Power.Optional(),
+				EnergyToday: dc.YieldDay.Optional(),
+				EnergyTotal: dc.YieldTotal.Optional(),
+				Voltage:     dc.Voltage.Optional(),
+				Current:     dc.Current.Optional(),
+			})
+		}
+		inverters = append(inverters, inverter)
+	}
+
+	p.mu.Lock()
+	p.inverters = inverters
+	p.mu.Unlock()
+
+	powerNow := d.Total.Power.Value()
+	energyToday := d.Total.YieldDay.Value()
+	energyTotal := d.Total.YieldTotal.Value()
+	status := models.SolarStatus{EnergyToday: energyToday, EnergyTotal: energyTotal, PowerNow: powerNow, Inverters: inverters}
+	return &status, nil
+}
+
+func (p *OpenDTUProvider) Inverters() []models.InverterStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.inverters
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenDTUGetSolarStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/livedata/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.URL.Query().Get("inv") {
+		case "":
+			w.Write([]byte(`{"inverters":[
+				{"serial":"114182100001","name":"Roof","AC":{"0":{"Power":{"v":350.5,"u":"W"},"YieldDay":{"v":1200,"u":"Wh"},"YieldTotal":{"v":321.5,"u":"kWh"}}},
+				 "DC":{"1":{"name":{"u":"West"},"Power":{"v":160,"u":"W"},"Voltage":{"v":31.2,"u":"V"}},"0":{"name":{"u":"East"},"Power":{"v":200,"u":"W"},"Current":{"v":6.1,"u":"A"}}}},
+				{"serial":"114182100002","name":"Shed"}],
+				"total":{"Power":{"v":450.5,"u":"W"},"YieldDay":{"v":1500,"u":"Wh"},"YieldTotal":{"v":400.25,"u":"kWh"}}}`))
+		case "114182100002":
+			w.Write([]byte(`{"inverters":[{"serial":"114182100002","name":"Shed","AC":{"0":{"Power":{"v":100,"u":"W"},"YieldDay":{"v":300,"u":"Wh"},"YieldTotal":{"v":78.75,"u":"kWh"}}},"DC":{"0":{"Power":{"v":104,"u":"W"}}}}]}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	provider := NewOpenDTUProvider("Site", server.URL, "", "", 10, nil)
+	status, err := provider.GetSolarStatus()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.PowerNow != 450.5 {
+		t.Errorf("Expected PowerNow 450.5, got %f", status.PowerNow)
+	}
+	if status.EnergyToday != 1500 {
+		t.Errorf("Expected EnergyToday 1500, got %f", status.EnergyToday)
+	}
+	if status.EnergyTotal != 400250 {
+		t.Errorf("Expected EnergyTotal 400250, got %f", status.EnergyTotal)
+	}
+
+	inverters := provider.Inverters()
+	if len(inverters) != 2 {
+		t.Fatalf("Expected 2 inverters, got %d", len(inverters))
+	}
+	roof := inverters[0]
+	if *roof.PowerNow != 350.5 || *roof.EnergyTotal != 321500 {
+		t.Errorf("Unexpected inverter status: %+v", roof)
+	}
+	if len(roof.Strings) != 2 || roof.Strings[0].Name != "East" || *roof.Strings[1].Voltage != 31.2 || roof.Strings[1].Current != nil || roof.Strings[0].EnergyToday != nil {
+		t.Errorf("Unexpected string status: %+v", roof.Strings)
+	}
+	shed := inverters[1]
+	if *shed.PowerNow != 100 || *shed.EnergyTotal != 78750 || shed.Strings[0].Name != "0" {
+		t.Errorf("Unexpected inverter status: %+v", shed)
+	}
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+// p1StaleAfter is how long the latest telegram stays valid. DSMR 4 meters send
+// a telegram every 10 seconds and DSMR 5 meters every second.
+const p1StaleAfter = time.Minute
+
+// p1ReconnectDelay is the wait before reopening a failed serial or TCP source.
+const p1ReconnectDelay = 5 * time.Second
+
+var p1Line = regexp.MustCompile(`^(\d+-\d+:\d+\.\d+\.\d+)\((.*)\)`)
+
+// p1Telegram holds the grid readings of a DSMR telegram. Powers are in W and
+// counters in Wh.
+type p1Telegram struct {
+	PowerImport float64
+	PowerExport float64
+	Tariffs     map[string]*models.GridTariff
+}
+
+// p1CRC computes the CRC16/ARC checksum DSMR 4 and 5 append to each telegram.
+func p1CRC(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// p1Value parses a value such as "001234.567*kWh" into W or Wh.
+func p1Value(raw string) (float64, error) {
+	value, unit, _ := strings.Cut(raw, "*")
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not convert [%s] to float: %s", raw, err)
+	}
+	if unit == "kWh" || unit == "kW" {
+		f *= 1000
+	}
+	return f, nil
+}
+
+// parseP1Telegram parses a complete telegram, from the "/" identification line
+// up to and including the "!" checksum line, and validates its checksum.
+func parseP1Telegram(raw string) (*p1Telegram, error) {
+	start := strings.Index(raw, "/")
+	end := strings.Index(raw, "!")
+	if start == -1 || end == -1 || end < start {
+		return nil, fmt.Errorf("incomplete telegram")
+	}
+	checksum := strings.TrimSpace(raw[end+1:])
+	if len(checksum) > 4 {
+		checksum = checksum[:4]
+	}
+	expected, err := strconv.ParseUint(checksum, 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid telegram checksum [%s]", checksum)
+	}
+	if crc := p1CRC([]byte(raw[start : end+1])); uint16(expected) != crc {
+		return nil, fmt.Errorf("telegram checksum mismatch: expected %04X, got %04X", expected, crc)
+	}
+
+	t := &p1Telegram{Tariffs: map[string]*models.GridTariff{}}
+	tariff := func(name string) *models.GridTariff {
+		if _, ok := t.Tariffs[name]; !ok {
+			t.Tariffs[name] = &models.GridTariff{Tariff: name}
+		}
+		return t.Tariffs[name]
+	}
+	for _, line := range strings.Split(raw[start:end], "\n") {
+		m := p1Line.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		obis, value := m[1], m[2]
+		switch obis {
+		case "1-0:1.8.1", "1-0:1.8.2", "1-0:2.8.1", "1-0:2.8.2":
+			v, err := p1Value(value)
+			if err != nil {
+				return nil, err
+			}
+			tt := tariff(obis[len(obis)-1:])
+			if strings.HasPrefix(obis, "1-0:1.") {
+				tt.EnergyImport = v
+			} else {
+				tt.EnergyExport = v
+			}
+		case "1-0:1.7.0", "1-0:2.7.0":
+			v, err := p1Value(value)
+			if err != nil {
+				return nil, err
+			}
+			if obis == "1-0:1.7.0" {
+				t.PowerImport = v
+			} else {
+				t.PowerExport = v
+			}
+		}
+	}
+	if len(t.Tariffs) == 0 {
+		return nil, fmt.Errorf("telegram contains no energy counters")
+	}
+	return t, nil
+}
+
+// readP1Telegrams splits a telegram stream and calls fn with each complete
+// telegram until the reader fails.
+func readP1Telegrams(r io.Reader, fn func(string)) error {
+	reader := bufio.NewReader(r)
+	var telegram strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if strings.HasPrefix(line, "/") {
+			telegram.Reset()
+		}
+		telegram.WriteString(line)
+		if strings.HasPrefix(line, "!") && strings.HasSuffix(line, "\n") {
+			fn(telegram.String())
+			telegram.Reset()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// P1Provider reads the DSMR P1 port of a Dutch or Belgian smart meter. The
+// source is either a serial device, a "tcp://host:port" ser2net socket or a
+// regular file that holds the most recent telegram. It measures the grid only
+// and reports no production.
+type P1Provider struct {
+	site    string
+	source  string
+	timeout int
+	db      *models.DataBase
+
+	mu       sync.Mutex
+	latest   *p1Telegram
+	received time.Time
+}
+
+func (p *P1Provider) Site() string {
+	return p.site
+}
+
+func (p *P1Provider) Timeout() int {
+	return p.timeout
+}
+
+func (p *P1Provider) DB() *models.DataBase {
+	return p.db
+}
+
+func NewP1Provider(site, source string, timeout int, db *models.DataBase) *P1Provider {
+	return &P1Provider{site: site, source: source, timeout: timeout, db: db}
+}
+
+func (p *P1Provider) isStream() bool {
+	if strings.HasPrefix(p.source, "tcp://") {
+		return true
+	}
+	s, err := os.Stat(p.source)
+	return err == nil && s.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *P1Provider) open() (io.ReadCloser, error) {
+	if strings.HasPrefix(p.source, "tcp://") {
+		return net.Dial("tcp", strings.TrimPrefix(p.source, "tcp://"))
+	}
+	return openP1Serial(p.source)
+}
+
+// Listen starts reading telegrams from a serial or TCP source in the
+// background, reconnecting whenever the source fails. File sources are read on
+// every poll instead.
+func (p *P1Provider) Listen() {
+	if !p.isStream() {
+		return
+	}
+	go func() {
+		for {
+			r, err := p.open()
+			if err != nil {
+				log.Printf("%s - Could not open P1 source [%s]: %s", p.site, p.source, err)
+			} else {
+				err = readP1Telegrams(r, p.handle)
+				r.Close()
+				log.Printf("%s - Lost P1 source [%s]: %s", p.site, p.source, err)
+			}
+			time.Sleep(p1ReconnectDelay)
+		}
+	}()
+}
+
+func (p *P1Provider) handle(raw string) {
+	t, err := parseP1Telegram(raw)
+	if err != nil {
+		log.Printf("%s - Discarding P1 telegram: %s", p.site, err)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.latest = t
+	p.received = time.Now()
+}
+
+func (p *P1Provider) HasProduction() bool {
+	return false
+}
+
+func (p *P1Provider) GetSolarStatus() (*models.SolarStatus, error) {
+	return &models.SolarStatus{}, nil
+}
+
+func (p *P1Provider) GetGridStatus() (*models.GridStatus, error) {
+	if p.isStream() {
+		p.mu.Lock()
+		latest, received := p.latest, p.received
+		p.mu.Unlock()
+		if latest == nil {
+			return nil, fmt.Errorf("no telegram received from [%s]", p.source)
+		}
+		if time.Since(received) > p1StaleAfter {
+			return nil, fmt.Errorf("no telegram received from [%s] since %s", p.source, received.Format(time.RFC3339))
+		}
+		return p1GridStatus(latest), nil
+	}
+
+	f, err := os.Open(p.source)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var t *p1Telegram
+	var lastErr error
+	readP1Telegrams(f, func(raw string) {
+		if parsed, err := parseP1Telegram(raw); err != nil {
+			lastErr = err
+		} else {
+			t, lastErr = parsed, nil
+		}
+	})
+	if t == nil {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no telegram found in [%s]", p.source)
+		}
+		return nil, lastErr
+	}
+	return p1GridStatus(t), nil
+}
+
+func p1GridStatus(t *p1Telegram) *models.GridStatus {
+	status := &models.GridStatus{PowerImport: t.PowerImport, PowerExport: t.PowerExport}
+	energyImport, energyExport := 0.0, 0.0
+	for _, name := range []string{"1", "2"} {
+		tt, ok := t.Tariffs[name]
+		if !ok {
+			continue
+		}
+		energyImport += tt.EnergyImport
+		energyExport += tt.EnergyExport
+		status.Tariffs = append(status.Tariffs, *tt)
+	}
+	if len(status.Tariffs) > 0 {
+		status.EnergyImport, status.EnergyExport = &energyImport, &energyExport
+	}
+	return status
+}
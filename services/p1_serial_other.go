@@ -0,0 +1,14 @@
+//go:build !linux
+
+package services
+
+import (
+	"io"
+	"os"
+)
+
+// openP1Serial opens a P1 serial device as is; configure it for 115200 baud
+// 8N1 beforehand, for example with stty.
+func openP1Serial(device string) (io.ReadCloser, error) {
+	return os.Open(device)
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseP1Telegram(t *testing.T) {
+	raw, err := os.ReadFile("testdata/dsmr5.txt")
+	if err != nil {
+		t.Fatalf("Error reading fixture: %v", err)
+	}
+	telegram, err := parseP1Telegram(string(raw))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if telegram.PowerImport != 192 {
+		t.Errorf("Expected PowerImport 192, got %f", telegram.PowerImport)
+	}
+	if telegram.PowerExport != 0 {
+		t.Errorf("Expected PowerExport 0, got %f", telegram.PowerExport)
+	}
+	if v := telegram.Tariffs["1"].EnergyImport; v != 1581123 {
+		t.Errorf("Expected tariff 1 import 1581123, got %f", v)
+	}
+	if v := telegram.Tariffs["2"].EnergyExport; v != 123456 {
+		t.Errorf("Expected tariff 2 export 123456, got %f", v)
+	}
+
+	raw, err = os.ReadFile("testdata/dsmr5_bad_crc.txt")
+	if err != nil {
+		t.Fatalf("Error reading fixture: %v", err)
+	}
+	if _, err := parseP1Telegram(string(raw)); err == nil {
+		t.Errorf("Expected checksum error")
+	}
+}
+
+func TestP1ProviderFileSource(t *testing.T) {
+	good, err := os.ReadFile("testdata/dsmr5.txt")
+	if err != nil {
+		t.Fatalf("Error reading fixture: %v", err)
+	}
+	bad, err := os.ReadFile("testdata/dsmr5_bad_crc.txt")
+	if err != nil {
+		t.Fatalf("Error reading fixture: %v", err)
+	}
+
+	// A stream dump with a partial telegram, a valid one and a corrupted one.
+	source := filepath.Join(t.TempDir(), "p1.txt")
+	dump := append([]byte("1-0:1.8.1(000001.000*kWh)\r\n!0000\r\n"), good...)
+	dump = append(dump, bad...)
+	if err := os.WriteFile(source, dump, 0644); err != nil {
+		t.Fatalf("Error writing source: %v", err)
+	}
+
+	provider := NewP1Provider("Site", source, 10, nil)
+	status, err := provider.GetGridStatus()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.EnergyImport == nil || *status.EnergyImport != 3016829 {
+		t.Errorf("Expected EnergyImport 3016829, got %v", status.EnergyImport)
+	}
+	if status.EnergyExport == nil || *status.EnergyExport != 123456 {
+		t.Errorf("Expected EnergyExport 123456, got %v", status.EnergyExport)
+	}
+	if len(status.Tariffs) != 2 || status.Tariffs[0].Tariff != "1" {
+		t.Errorf("Unexpected tariffs: %+v", status.Tariffs)
+	}
+}
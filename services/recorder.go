@@ -0,0 +1,383 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+const redacted = "REDACTED"
+
+// secretKeys are the query parameters, form fields, JSON keys and headers
+// whose values are redacted from recordings, compared case-insensitively.
+var secretKeys = map[string]bool{
+	"account":         true,
+	"access_token":    true,
+	"api_key":         true,
+	"apikey":          true,
+	"authorization":   true,
+	"cookie":          true,
+	"email":           true,
+	"password":        true,
+	"pwd":             true,
+	"systemcode":      true,
+	"token":           true,
+	"uid":             true,
+	"user":            true,
+	"userid":          true,
+	"username":        true,
+	"usernamedisplay": true,
+	"xsrf-token":      true,
+}
+
+// HTTPProvider is implemented by providers that talk HTTP, so their exchanges
+// can be recorded and replayed.
+type HTTPProvider interface {
+	Transport() http.RoundTripper
+	SetTransport(http.RoundTripper)
+}
+
+// Exchange is a recorded HTTP request and its response.
+type Exchange struct {
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	RequestBody     string      `json:"request_body"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"response_headers"`
+	ResponseBody    string      `json:"response_body"`
+}
+
+// RecordingTransport passes requests on to the next transport and writes each
+// exchange, with secrets redacted, to a numbered JSON file in its directory.
+// Only the last limit recordings are kept, so a long capture does not fill
+// the disk; a limit of 0 keeps all of them. A recording that cannot be written
+// is logged and does not fail the request.
+type RecordingTransport struct {
+	dir   string
+	limit int
+	next  http.RoundTripper
+
+	mu sync.Mutex
+	n  int
+}
+
+func NewRecordingTransport(dir string, limit int, next http.RoundTripper) (*RecordingTransport, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	// Continue after the last recording, as older ones may have been removed.
+	n := 0
+	for _, f := range recordings(dir) {
+		n = recordingNumber(f)
+	}
+	return &RecordingTransport{dir: dir, limit: limit, next: next, n: n}, nil
+}
+
+// recordingNumber returns the number of a recording file, or 0 when its name
+// is not a number.
+func recordingNumber(file string) int {
+	n, _ := strconv.Atoi(strings.TrimSuffix(filepath.Base(file), ".json"))
+	return n
+}
+
+// recordings returns the recording files in dir in the order they were made.
+func recordings(dir string) []string {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	sort.SliceStable(files, func(i, j int) bool {
+		if a, b := recordingNumber(files[i]), recordingNumber(files[j]); a != b {
+			return a < b
+		}
+		return files[i] < files[j]
+	})
+	return files
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resBody, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+
+	e := Exchange{
+		Method:          req.Method,
+		URL:             redactURL(req.URL),
+		RequestHeaders:  redactHeaders(req.Header),
+		RequestBody:     redactBody(reqBody, req.Header.Get("Content-Type")),
+		Status:          res.StatusCode,
+		ResponseHeaders: redactHeaders(res.Header),
+		ResponseBody:    redactBody(resBody, res.Header.Get("Content-Type")),
+	}
+	if err := t.record(e); err != nil {
+		log.Printf("Could not record %s %s: %s", req.Method, req.URL.Path, err)
+	}
+	return res, nil
+}
+
+// record writes e to the next recording file and removes the recordings
+// beyond the limit.
+func (t *RecordingTransport) record(e Exchange) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.n++
+	n := t.n
+	t.mu.Unlock()
+	name := filepath.Join(t.dir, fmt.Sprintf("%04d.json", n))
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		return fmt.Errorf("could not write recording [%s]: %s", name, err)
+	}
+	if t.limit > 0 {
+		for _, f := range recordings(t.dir) {
+			if recordingNumber(f) <= n-t.limit {
+				os.Remove(f)
+			}
+		}
+	}
+	return nil
+}
+
+func redactURL(u *neturl.URL) string {
+	c := *u
+	c.User = nil
+	query := c.Query()
+	for key := range query {
+		if secretKeys[strings.ToLower(key)] {
+			query.Set(key, redacted)
+		}
+	}
+	c.RawQuery = query.Encode()
+	return c.String()
+}
+
+func redactHeaders(h http.Header) http.Header {
+	out := http.Header{}
+	for key, values := range h {
+		switch {
+		case strings.EqualFold(key, "Set-Cookie"):
+			// Keep the cookie names so the session handling still works.
+			for _, v := range values {
+				name, _, _ := strings.Cut(v, "=")
+				out.Add(key, name+"="+redacted)
+			}
+		case strings.EqualFold(key, "Cookie"):
+			var cookies []string
+			for _, c := range strings.Split(strings.Join(values, "; "), ";") {
+				name, _, _ := strings.Cut(strings.TrimSpace(c), "=")
+				cookies = append(cookies, name+"="+redacted)
+			}
+			out.Set(key, strings.Join(cookies, "; "))
+		case secretKeys[strings.ToLower(key)]:
+			out.Set(key, redacted)
+		default:
+			out[key] = values
+		}
+	}
+	return out
+}
+
+func redactBody(body []byte, contentType string) string {
+	var doc interface{}
+	if json.Unmarshal(body, &doc) == nil {
+		redactJSON(doc)
+		data, _ := json.Marshal(doc)
+		return string(data)
+	}
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		if form, err := neturl.ParseQuery(string(body)); err == nil {
+			for key := range form {
+				if secretKeys[strings.ToLower(key)] {
+					form.Set(key, redacted)
+				}
+			}
+			return form.Encode()
+		}
+	}
+	return string(body)
+}
+
+// redactJSON redacts the values of secret keys in doc. Numbers become 0 and
+// booleans false rather than a string, so replayed responses still decode;
+// objects and arrays under a secret key have their own secret keys redacted.
+func redactJSON(doc interface{}) {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if !secretKeys[strings.ToLower(key)] {
+				redactJSON(child)
+				continue
+			}
+			switch child.(type) {
+			case string:
+				v[key] = redacted
+			case float64:
+				v[key] = 0
+			case bool:
+				v[key] = false
+			default:
+				redactJSON(child)
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			redactJSON(child)
+		}
+	}
+}
+
+// ReplayTransport answers requests from a directory of recorded exchanges. A
+// request gets the next recording with the same method and path; when all of
+// them have been served it starts over, so repeated polls keep working.
+type ReplayTransport struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+	next      int
+}
+
+func NewReplayTransport(dir string) (*ReplayTransport, error) {
+	files := recordings(dir)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recordings found in [%s]", dir)
+	}
+
+	t := &ReplayTransport{}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		e := Exchange{}
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("invalid recording [%s]: %s", f, err)
+		}
+		t.exchanges = append(t.exchanges, e)
+	}
+	return t, nil
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := len(t.exchanges)
+	for i := 0; i < n; i++ {
+		idx := (t.next + i) % n
+		e := t.exchanges[idx]
+		u, err := neturl.Parse(e.URL)
+		if err != nil || e.Method != req.Method || u.Path != req.URL.Path {
+			continue
+		}
+		t.next = idx + 1
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+			StatusCode:    e.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        e.ResponseHeaders.Clone(),
+			Body:          io.NopCloser(strings.NewReader(e.ResponseBody)),
+			ContentLength: int64(len(e.ResponseBody)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("no recording for %s %s", req.Method, req.URL.Path)
+}
+
+// ReplayConfig describes a site that replays recorded exchanges. Pid and
+// BaseURL are only used where they end up in the request path; generic_http
+// and homeassistant need the fields or entities of the recorded site.
+type ReplayConfig struct {
+	Site          string              `yaml:"site"`
+	Provider      string              `yaml:"provider"`
+	Pid           string              `yaml:"pid"`
+	BaseURL       string              `yaml:"base_url"`
+	Dir           string              `yaml:"dir"`
+	Timeout       int                 `yaml:"timeout"`
+	GenericHTTP   GenericHTTPConfig   `yaml:"generic_http"`
+	HomeAssistant HomeAssistantConfig `yaml:"homeassistant"`
+}
+
+// NewReplayProvider creates a provider of the configured type that parses the
+// recordings in its directory instead of calling the vendor API. Credentials
+// are not needed.
+func NewReplayProvider(config ReplayConfig, timeout int, db *models.DataBase) (SolarStatusProvider, error) {
+	site, baseURL, pid := config.Site, config.BaseURL, config.Pid
+	transport, err := NewReplayTransport(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if baseURL == "" {
+		baseURL = "http://replay"
+	}
+
+	var p interface {
+		SolarStatusProvider
+		HTTPProvider
+	}
+	switch config.Provider {
+	case "solaredge":
+		p = NewSolarEdgeProvider(site, redacted, pid, timeout, db)
+	case "sems":
+		p, err = NewSemsProvider(site, redacted, redacted, "", pid, timeout, db)
+	case "ginlong":
+		p = NewGinlongProvider(site, redacted, redacted, pid, timeout, db)
+	case "omnik":
+		p = NewOmnikProvider(site, baseURL, pid, timeout, db)
+	case "fusionsolar":
+		p = NewFusionSolarProvider(site, baseURL, redacted, redacted, pid, timeout, db)
+	case "growatt":
+		p = NewGrowattProvider(site, baseURL, redacted, redacted, pid, timeout, db)
+	case "opendtu":
+		p = NewOpenDTUProvider(site, baseURL, "", "", timeout, db)
+	case "ahoydtu":
+		p = NewAhoyDTUProvider(site, baseURL, timeout, db)
+	case "generic_http":
+		p, err = NewGenericHTTPProvider(site, config.GenericHTTP, timeout, db)
+	case "homeassistant":
+		ha := config.HomeAssistant
+		ha.BaseURL, ha.Token = baseURL, redacted
+		p, err = NewHomeAssistantProvider(site, ha, timeout, db)
+	default:
+		return nil, fmt.Errorf("%s - provider [%s] cannot be replayed", site, config.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.SetTransport(transport)
+	return p, nil
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fakeTransport func(*http.Request) (*http.Response, error)
+
+func (f fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	vendor := fakeTransport(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Query().Get("api_key") != "very-secret" {
+			t.Errorf("Expected the real api key to reach the vendor")
+		}
+		body := `{"overview":{"lifeTimeData":{"energy":1000000},"lastYearData":{"energy":500000},"lastMonthData":{"energy":40000},"lastDayData":{"energy":3000},"currentPower":{"power":750}}}`
+		return &http.Response{
+			StatusCode: 200,
+			Status:     "200 OK",
+			Header:     http.Header{"Content-Type": {"application/json"}, "Set-Cookie": {"SESSION=abc123; Path=/"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}, nil
+	})
+
+	recorder, err := NewRecordingTransport(dir, 0, vendor)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	live := NewSolarEdgeProvider("Site", "very-secret", "12345", 10, nil)
+	live.SetTransport(recorder)
+	recorded, err := live.GetSolarStatus()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 recording, got %d", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	if strings.Contains(string(data), "very-secret") || strings.Contains(string(data), "abc123") {
+		t.Errorf("Expected secrets to be redacted, got %s", data)
+	}
+
+	replay, err := NewReplayProvider(ReplayConfig{Site: "Site", Provider: "solaredge", Pid: "12345", Dir: dir}, 10, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		replayed, err := replay.GetSolarStatus()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(replayed, recorded) {
+			t.Errorf("Expected %+v, got %+v", recorded, replayed)
+		}
+	}
+
+	other, err := NewReplayProvider(ReplayConfig{Site: "Site", Provider: "solaredge", Pid: "99999", Dir: dir}, 10, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := other.GetSolarStatus(); err == nil {
+		t.Errorf("Expected error for a request without recording")
+	}
+}
+
+func TestReplayHomeAssistant(t *testing.T) {
+	dir := t.TempDir()
+	vendor := fakeTransport(func(req *http.Request) (*http.Response, error) {
+		body := `[{"entity_id":"sensor.power","state":"1.5","attributes":{"unit_of_measurement":"kW"}},{"entity_id":"sensor.today","state":"850","attributes":{"unit_of_measurement":"Wh"}}]`
+		return &http.Response{StatusCode: 200, Status: "200 OK", Header: http.Header{"Content-Type": {"application/json"}}, Body: io.NopCloser(strings.NewReader(body))}, nil
+	})
+	recorder, _ := NewRecordingTransport(dir, 0, vendor)
+	entities := map[string]string{"power_now": "sensor.power", "energy_today": "sensor.today"}
+	live, err := NewHomeAssistantProvider("Site", HomeAssistantConfig{BaseURL: "http://ha.local:8123", Token: "very-secret", Entities: entities}, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live.SetTransport(recorder)
+	if _, err := live.GetSolarStatus(); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := NewReplayProvider(ReplayConfig{Site: "Site", Provider: "homeassistant", Dir: dir, HomeAssistant: HomeAssistantConfig{Entities: entities}}, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := replay.GetSolarStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.PowerNow != 1500 || status.EnergyToday != 850 {
+		t.Errorf("Unexpected replayed status %+v", status)
+	}
+}
+
+func TestRecordingLimit(t *testing.T) {
+	dir := t.TempDir()
+	vendor := fakeTransport(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Status: "200 OK", Body: io.NopCloser(strings.NewReader(req.URL.Query().Get("n")))}, nil
+	})
+	recorder, _ := NewRecordingTransport(dir, 3, vendor)
+	for n := 1; n <= 12; n++ {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://vendor/data?n=%d", n), nil)
+		if _, err := recorder.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := recordings(dir)
+	if len(files) != 3 || filepath.Base(files[0]) != "0010.json" {
+		t.Fatalf("Expected the last 3 recordings, got %v", files)
+	}
+
+	// A new recorder continues after the last recording.
+	recorder, _ = NewRecordingTransport(dir, 3, vendor)
+	req, _ := http.NewRequest(http.MethodGet, "http://vendor/data?n=13", nil)
+	recorder.RoundTrip(req)
+	if files := recordings(dir); len(files) != 3 || filepath.Base(files[2]) != "0013.json" {
+		t.Errorf("Expected recording 13 to replace the oldest, got %v", files)
+	}
+}
+
+func TestRecordingFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "recordings")
+	vendor := fakeTransport(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Status: "200 OK", Body: io.NopCloser(strings.NewReader("ok"))}, nil
+	})
+	recorder, err := NewRecordingTransport(dir, 0, vendor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A recording that cannot be written does not fail the request.
+	os.RemoveAll(dir)
+	req, _ := http.NewRequest(http.MethodGet, "http://vendor/data", nil)
+	res, err := recorder.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("Expected the response without a recording, got %v", err)
+	}
+	if body, _ := io.ReadAll(res.Body); string(body) != "ok" {
+		t.Errorf("Expected the vendor response, got %s", body)
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	form := redactBody([]byte("userName=alice&password=secret&lan=2"), "application/x-www-form-urlencoded")
+	if strings.Contains(form, "alice") || strings.Contains(form, "secret") || !strings.Contains(form, "lan=2") {
+		t.Errorf("Unexpected redacted form: %s", form)
+	}
+	body := redactBody([]byte(`{"data":{"token":"t0k3n","power":12,"users":[{"email":"a@b.c"}]}}`), "application/json")
+	if strings.Contains(body, "t0k3n") || strings.Contains(body, "a@b.c") || !strings.Contains(body, `"power":12`) {
+		t.Errorf("Unexpected redacted json: %s", body)
+	}
+	body = redactBody([]byte(`{"result":{"userId":1234567,"uid":987654,"user":{"id":4242,"token":"t0k3n"}}}`), "application/json")
+	if strings.Contains(body, "1234567") || strings.Contains(body, "987654") || strings.Contains(body, "t0k3n") || !strings.Contains(body, `"id":4242`) {
+		t.Errorf("Expected numeric secrets to be redacted: %s", body)
+	}
+}
@@ -1,27 +1,180 @@
 package services
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	neturl "net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rvben/solar_exporter/models"
 )
 
+// semsRegions maps the SEMS regions onto their API base. The login response
+// names the API base to use afterwards, so the region only picks where to log
+// in.
+var semsRegions = map[string]string{
+	"":   "https://www.semsportal.com/api/",
+	"eu": "https://eu.semsportal.com/api/",
+	"us": "https://us.semsportal.com/api/",
+	"hk": "https://hk.semsportal.com/api/",
+}
+
+// semsAuthCodes are the response codes SEMS uses for an expired or invalid
+// session token.
+var semsAuthCodes = map[string]bool{
+	"100001": true,
+	"100002": true,
+}
+
+// semsToken is sent as a JSON encoded Token header with every request. Before
+// logging in only the client fields are set.
+type semsToken struct {
+	UID       string `json:"uid,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Token     string `json:"token,omitempty"`
+	Client    string `json:"client"`
+	Version   string `json:"version"`
+	Language  string `json:"language"`
+}
+
+var semsAnonymousToken = semsToken{Client: "ios", Version: "v2.1.0", Language: "en"}
+
+type semsResponse struct {
+	HasError   bool            `json:"hasError"`
+	Code       json.RawMessage `json:"code"`
+	Msg        string          `json:"msg"`
+	Data       json.RawMessage `json:"data"`
+	Components struct {
+		API string `json:"api"`
+	} `json:"components"`
+}
+
+// code returns the response code, which SEMS sends as a number or a string.
+func (r semsResponse) code() string {
+	return strings.Trim(string(r.Code), `"`)
+}
+
+// semsInverterModes names the status codes SEMS reports per inverter.
+var semsInverterModes = map[int]string{
+	-1: "offline",
+	0:  "waiting",
+	1:  "normal",
+	2:  "fault",
+}
+
+// semsPower parses the power flow readings, which SEMS formats as "1500(W)".
+func semsPower(raw string) float64 {
+	raw = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(raw), "(W)"))
+	v, _ := strconv.ParseFloat(raw, 64)
+	return v
+}
+
+type semsInverter struct {
+	SN         string `json:"sn"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Status     int    `json:"status"`
+	InvertFull struct {
+		Pac         float64 `json:"pac"`
+		Eday        float64 `json:"eday"`
+		Etotal      float64 `json:"etotal"`
+		Vac1        float64 `json:"vac1"`
+		Iac1        float64 `json:"iac1"`
+		Fac1        float64 `json:"fac1"`
+		Temperature float64 `json:"tempperature"`
+		Vpv1        float64 `json:"vpv1"`
+		Vpv2        float64 `json:"vpv2"`
+		Vpv3        float64 `json:"vpv3"`
+		Vpv4        float64 `json:"vpv4"`
+		Ipv1        float64 `json:"ipv1"`
+		Ipv2        float64 `json:"ipv2"`
+		Ipv3        float64 `json:"ipv3"`
+		Ipv4        float64 `json:"ipv4"`
+	} `json:"invert_full"`
+	D struct {
+		Warning string `json:"warning"`
+	} `json:"d"`
+}
+
+// status converts the inverter to the exported model. Energies are reported
+// in kWh and the PV inputs as voltage and current per MPPT; inputs without a
+// voltage are not connected.
+func (i semsInverter) status() models.InverterStatus {
+	f := i.InvertFull
+	energyToday, energyTotal := f.Eday*1000, f.Etotal*1000
+	inv := models.InverterStatus{
+		Serial:       i.SN,
+		Name:         i.Name,
+		Model:        i.Type,
+		Manufacturer: "GoodWe",
+		Mode:         semsInverterModes[i.Status],
+		PowerNow:     &f.Pac,
+		EnergyToday:  &energyToday,
+		EnergyTotal:  &energyTotal,
+		ACVoltage:    &f.Vac1,
+		ACCurrent:    &f.Iac1,
+		ACFrequency:  &f.Fac1,
+		Temperature:  &f.Temperature,
+	}
+	if inv.Mode == "" {
+		inv.Mode = strconv.Itoa(i.Status)
+	}
+	voltages := []float64{f.Vpv1, f.Vpv2, f.Vpv3, f.Vpv4}
+	currents := []float64{f.Ipv1, f.Ipv2, f.Ipv3, f.Ipv4}
+	for n := range voltages {
+		if voltages[n] == 0 {
+			continue
+		}
+		// SEMS reports no energy per input.
+		power := voltages[n] * currents[n]
+		inv.Strings = append(inv.Strings, models.StringStatus{
+			Name:     fmt.Sprintf("pv%d", n+1),
+			PowerNow: &power,
+			Voltage:  &voltages[n],
+			Current:  &currents[n],
+		})
+	}
+	if w := strings.TrimSpace(i.D.Warning); w != "" && !strings.EqualFold(w, "normal") {
+		inv.Warnings = []string{w}
+	}
+	return inv
+}
+
+// semsPowerFlow is the power flow of a station. The status fields give the
+// direction: 1 when the power flows into the house, -1 when it flows out and
+// 0 when idle.
+type semsPowerFlow struct {
+	Load          string   `json:"load"`
+	Grid          string   `json:"grid"`
+	GridStatus    int      `json:"gridStatus"`
+	Battery       string   `json:"bettery"`
+	BatteryStatus int      `json:"betteryStatus"`
+	SOC           *float64 `json:"soc"`
+}
+
 type SemsProvider struct {
-	user     string
-	password string
-	token    string
-	cookie   string
-	site     string
-	timeout  int
-	db       *models.DataBase
+	user      string
+	password  string
+	region    string
+	stationID string
+	site      string
+	timeout   int
+	db        *models.DataBase
+	client    *http.Client
+
+	mu        sync.Mutex
+	apiBase   string
+	token     *semsToken
+	inverters []models.InverterStatus
+	grid      *models.GridStatus
+	load      *models.LoadStatus
+	battery   *models.BatteryStatus
 }
 
 func (p *SemsProvider) Site() string {
@@ -36,123 +189,251 @@ func (p *SemsProvider) DB() *models.DataBase {
 	return p.db
 }
 
-func NewSemsProvider(site, user, password string, timeout int, db *models.DataBase) *SemsProvider {
-	return &SemsProvider{site: site, user: user, password: password, timeout: timeout, db: db}
+// NewSemsProvider creates a SEMS provider. The region (eu, us or hk) may be
+// empty to use the global portal; stationID may be empty to use the first
+// power station of the account.
+func NewSemsProvider(site, user, password, region, stationID string, timeout int, db *models.DataBase) (*SemsProvider, error) {
+	if _, ok := semsRegions[region]; !ok {
+		return nil, fmt.Errorf("%s - unknown SEMS region [%s], expected eu, us or hk", site, region)
+	}
+	return &SemsProvider{site: site, user: user, password: password, region: region, stationID: stationID, timeout: timeout, db: db, client: &http.Client{Timeout: 60 * time.Second}}, nil
 }
 
-func (p *SemsProvider) login() error {
-	var cookie, token string
+func (p *SemsProvider) Transport() http.RoundTripper {
+	return p.client.Transport
+}
 
-	log.Printf("Logging in as user [%s]", p.user)
-	url := "https://www.semsportal.com/Home/Login"
-	data := neturl.Values{}
-	data.Set("account", p.user)
-	data.Set("pwd", p.password)
+func (p *SemsProvider) SetTransport(t http.RoundTripper) {
+	p.client.Transport = t
+}
 
-	client := &http.Client{}
-	r, err := http.NewRequest("POST", url, strings.NewReader(data.Encode()))
+// post calls a v2 API and returns the response, also when it carries an error
+// code.
+func (p *SemsProvider) post(url string, token semsToken, payload interface{}) (*semsResponse, error) {
+	body, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("could not create request for url [%s]: %s", url, err)
+		return nil, err
+	}
+	header, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
 	}
-	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	r.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
 
-	res, err := client.Do(r)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
 	if err != nil {
-		return fmt.Errorf("could succesfully finish request [%s]: %s", url, err)
+		return nil, fmt.Errorf("could not create request for url [%s]: %s", url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Token", string(header))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could succesfully finish request [%s]: %s", url, err)
 	}
 	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, &statusError{code: res.StatusCode, status: res.Status}
+	}
+
 	bodyBytes, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read body from request: %s", err)
+		return nil, fmt.Errorf("failed to read body from request: %s", err)
 	}
-	for _, c := range res.Cookies() {
-		if c.Name == "ASP.NET_SessionId" {
-			cookie = c.Value
-		}
+	response := &semsResponse{}
+	if err := json.Unmarshal(bodyBytes, response); err != nil {
+		return nil, fmt.Errorf("failed to parse body to json: %s", err)
 	}
-	response := struct {
-		Code int    `json:"code"`
-		Msg  string `json:"msg"`
-		Data struct {
-			Redirect string `json:"redirect"`
-		} `json:"data"`
-	}{}
-	json.Unmarshal(bodyBytes, &response)
-	split := strings.Split(response.Data.Redirect, "/")
-	token = split[len(split)-1]
-	if response.Code != 0 {
-		return fmt.Errorf("failed to log in as user [%s]", p.user)
+	return response, nil
+}
+
+func (p *SemsProvider) login() error {
+	log.Printf("%s - Logging in as user [%s]", p.site, p.user)
+	url := semsRegions[p.region] + "v2/Common/CrossLogin"
+	response, err := p.post(url, semsAnonymousToken, map[string]string{"account": p.user, "pwd": p.password})
+	if unauthorized(err) {
+		return &LoginError{Site: p.site, User: p.user, Err: err}
+	}
+	if err != nil {
+		return err
+	}
+	if response.HasError || response.code() != "0" {
+		return &LoginError{Site: p.site, User: p.user, Err: fmt.Errorf("%s", response.Msg)}
+	}
+
+	token := &semsToken{}
+	if err := json.Unmarshal(response.Data, token); err != nil || token.Token == "" {
+		return &LoginError{Site: p.site, User: p.user, Err: fmt.Errorf("no token in login response")}
 	}
-	log.Printf("%s - Succesfully logged in as user [%s]\n", p.site, p.user)
 	p.token = token
-	p.cookie = cookie
+	p.apiBase = semsRegions[p.region]
+	if response.Components.API != "" {
+		p.apiBase = response.Components.API
+		if !strings.HasSuffix(p.apiBase, "/") {
+			p.apiBase += "/"
+		}
+	}
+	log.Printf("%s - Succesfully logged in as user [%s] at [%s]\n", p.site, p.user, p.apiBase)
 	return nil
 }
 
-func (p *SemsProvider) GetSolarStatus() (*models.SolarStatus, error) {
-	err := p.login()
-	if err != nil {
-		return nil, err
+// call performs an authenticated API call and decodes its data into v. The
+// session token is reused between calls and only renewed when SEMS no longer
+// accepts it.
+func (p *SemsProvider) call(api string, payload interface{}, v interface{}) error {
+	for attempt := 0; attempt < 2; attempt++ {
+		if p.token == nil {
+			if err := p.login(); err != nil {
+				return err
+			}
+		}
+		response, err := p.post(p.apiBase+api, *p.token, payload)
+		if err != nil {
+			return err
+		}
+		if semsAuthCodes[response.code()] {
+			log.Printf("%s - Session expired: %s", p.site, response.Msg)
+			p.token = nil
+			continue
+		}
+		if response.HasError || response.code() != "0" {
+			return fmt.Errorf("failed to call [%s] for site [%s]: %s", api, p.site, response.Msg)
+		}
+		if err := json.Unmarshal(response.Data, v); err != nil {
+			return fmt.Errorf("failed to parse body to json: %s", err)
+		}
+		return nil
 	}
-	client := &http.Client{
-		Timeout: 60 * time.Second,
+	return &LoginError{Site: p.site, User: p.user, Err: fmt.Errorf("session not accepted after re-login")}
+}
+
+func (p *SemsProvider) powerStationID() (string, error) {
+	if p.stationID != "" {
+		return p.stationID, nil
+	}
+	stations := struct {
+		List []struct {
+			ID   string `json:"id"`
+			Name string `json:"stationname"`
+		} `json:"list"`
+	}{}
+	if err := p.call("v2/HistoryData/QueryPowerStationByHistory", map[string]string{}, &stations); err != nil {
+		return "", err
 	}
+	if len(stations.List) == 0 {
+		return "", fmt.Errorf("no power stations found for user [%s]", p.user)
+	}
+	p.stationID = stations.List[0].ID
+	log.Printf("%s - Using power station [%s] (%s)", p.site, p.stationID, stations.List[0].Name)
+	return p.stationID, nil
+}
 
-	url := "https://www.semsportal.com/GopsApi/Post?s=v3/PowerStation/GetMonitorDetailByPowerstationId"
-	data := neturl.Values{}
-	data.Set("str", fmt.Sprintf("{\"api\":\"v3/PowerStation/GetMonitorDetailByPowerstationId\",\"param\":{\"powerStationId\":\"%s\"}}", p.token))
+func (p *SemsProvider) GetSolarStatus() (*models.SolarStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	req, err := http.NewRequest("POST", url, strings.NewReader(data.Encode()))
+	id, err := p.powerStationID()
 	if err != nil {
-		return nil, fmt.Errorf("could not create request for url [%s]: %s", url, err)
+		return nil, err
 	}
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
-	req.Header.Set("Cookie", "ASP.NET_SessionId="+p.cookie)
 
-	res, err := client.Do(req)
+	rawStatus := struct {
+		Kpi struct {
+			MonthGeneration float64  `json:"month_generation"`
+			Pac             float64  `json:"pac"`
+			Power           float64  `json:"power"`
+			TotalPower      float64  `json:"total_power"`
+			DayIncome       *float64 `json:"day_income"`
+			TotalIncome     *float64 `json:"total_income"`
+			YieldRate       float64  `json:"yield_rate"`
+			Currency        string   `json:"currency"`
+		} `json:"kpi"`
+		Inverter  []semsInverter `json:"inverter"`
+		PowerFlow *semsPowerFlow `json:"powerflow"`
+	}{}
+	err = p.call("v2/PowerStation/GetMonitorDetailByPowerstationId", map[string]string{"powerStationId": id}, &rawStatus)
 	if err != nil {
-		return nil, fmt.Errorf("could succesfully finish request [%s]: %s", url, err)
-	}
-	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+		return nil, err
 	}
 
-	bodyBytes, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read body from request: %s", err)
+	p.inverters = nil
+	for _, inv := range rawStatus.Inverter {
+		p.inverters = append(p.inverters, inv.status())
 	}
-	rawStatus := struct {
-		Language string      `json:"language"`
-		Function interface{} `json:"function"`
-		HasError bool        `json:"hasError"`
-		Msg      string      `json:"msg"`
-		Code     string      `json:"code"`
-		Data     struct {
-			Kpi struct {
-				MonthGeneration float64 `json:"month_generation"`
-				Pac             float64 `json:"pac"`
-				Power           float64 `json:"power"`
-				TotalPower      float64 `json:"total_power"`
-				DayIncome       float64 `json:"day_income"`
-				TotalIncome     float64 `json:"total_income"`
-				YieldRate       float64 `json:"yield_rate"`
-				Currency        string  `json:"currency"`
-			} `json:"kpi"`
+	p.grid, p.load, p.battery = nil, nil, nil
+	if f := rawStatus.PowerFlow; f != nil {
+		grid := semsPower(f.Grid)
+		p.grid = &models.GridStatus{}
+		switch f.GridStatus {
+		case 1:
+			p.grid.PowerImport = grid
+		case -1:
+			p.grid.PowerExport = grid
+		}
+		p.load = &models.LoadStatus{PowerNow: semsPower(f.Load)}
+		// Stations without a battery have no state of charge.
+		if f.SOC != nil {
+			battery := semsPower(f.Battery)
+			p.battery = &models.BatteryStatus{StateOfCharge: f.SOC}
+			switch f.BatteryStatus {
+			case 1:
+				p.battery.PowerDischarge = battery
+			case -1:
+				p.battery.PowerCharge = battery
+			}
 		}
-	}{}
-	json.Unmarshal(bodyBytes, &rawStatus)
-	if rawStatus.Code != "0" {
-		return nil, fmt.Errorf("failed to retrieve status for site [%s]: %s", p.site, rawStatus.Msg)
 	}
 
-	d := rawStatus.Data
+	d := rawStatus
 	energyToday := d.Kpi.Power * 1000           // Eday is in kW
 	energyMonth := d.Kpi.MonthGeneration * 1000 // Emonth is in kW
 	energyTotal := d.Kpi.TotalPower * 1000      // Etotal is in kW
 	powerNow := d.Kpi.Pac                       // Pac is in W
-	status := models.SolarStatus{EnergyToday: energyToday, EnergyMonth: energyMonth, EnergyTotal: energyTotal, PowerNow: powerNow}
+	// Stations without a tariff report no income.
+	var financial *models.FinancialStatus
+	if d.Kpi.Currency != "" || d.Kpi.DayIncome != nil || d.Kpi.TotalIncome != nil {
+		financial = &models.FinancialStatus{Currency: d.Kpi.Currency, IncomeToday: d.Kpi.DayIncome}
+		if d.Kpi.TotalIncome != nil {
+			financial.IncomeTotal = *d.Kpi.TotalIncome
+		}
+	}
+	status := models.SolarStatus{EnergyToday: energyToday, EnergyMonth: energyMonth, EnergyTotal: energyTotal, PowerNow: powerNow, Inverters: p.inverters, Grid: p.grid, Load: p.load, Battery: p.battery, Financial: financial}
+	return &status, nil
+}
+
+func (p *SemsProvider) Inverters() []models.InverterStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.inverters
+}
+
+// GetGridStatus returns the grid power of the last poll. SEMS only reports the
+// power, not the meter counters.
+func (p *SemsProvider) GetGridStatus() (*models.GridStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.grid == nil {
+		return nil, nil
+	}
+	status := *p.grid
+	return &status, nil
+}
+
+func (p *SemsProvider) GetLoadStatus() (*models.LoadStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.load == nil {
+		return nil, nil
+	}
+	status := *p.load
+	return &status, nil
+}
+
+func (p *SemsProvider) GetBatteryStatus() (*models.BatteryStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.battery == nil {
+		return nil, nil
+	}
+	status := *p.battery
 	return &status, nil
 }
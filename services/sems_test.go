@@ -0,0 +1,170 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func semsTransport(t *testing.T, requests *[]string, expired *bool) fakeTransport {
+	return fakeTransport(func(req *http.Request) (*http.Response, error) {
+		*requests = append(*requests, req.URL.Host+req.URL.Path)
+		token := semsToken{}
+		if err := json.Unmarshal([]byte(req.Header.Get("Token")), &token); err != nil {
+			t.Errorf("Expected a JSON Token header, got %s", req.Header.Get("Token"))
+		}
+		body, _ := io.ReadAll(req.Body)
+
+		var response string
+		switch req.URL.Path {
+		case "/api/v2/Common/CrossLogin":
+			if !strings.Contains(string(body), `"pwd":"secret"`) {
+				response = `{"hasError":true,"code":100005,"msg":"Email or password error."}`
+				break
+			}
+			*expired = false
+			response = `{"hasError":false,"code":0,"msg":"Successful","data":{"uid":"u1","timestamp":1700000000,"token":"t1","client":"ios","version":"","language":"en"},"components":{"api":"https://eu.semsportal.com/api/"}}`
+		case "/api/v2/HistoryData/QueryPowerStationByHistory":
+			response = `{"hasError":false,"code":0,"msg":"success","data":{"list":[{"id":"station-1","stationname":"Home"}]}}`
+		case "/api/v2/PowerStation/GetMonitorDetailByPowerstationId":
+			if *expired || token.Token != "t1" {
+				response = `{"hasError":true,"code":100002,"msg":"The authorization has expired, please log in again."}`
+				break
+			}
+			if !strings.Contains(string(body), `"powerStationId":"station-1"`) {
+				t.Errorf("Unexpected body %s", body)
+			}
+			response = `{"hasError":false,"code":0,"msg":"success","data":{"kpi":{"month_generation":120.5,"pac":1500,"power":8.2,"total_power":9000.1}}}`
+		}
+		return &http.Response{StatusCode: 200, Status: "200 OK", Body: io.NopCloser(strings.NewReader(response))}, nil
+	})
+}
+
+func TestSemsSessionReuse(t *testing.T) {
+	var requests []string
+	expired := false
+	p, err := NewSemsProvider("test", "user", "secret", "eu", "", 60, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetTransport(semsTransport(t, &requests, &expired))
+
+	for i := 0; i < 2; i++ {
+		status, err := p.GetSolarStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.PowerNow != 1500 || status.EnergyToday != 8200 || status.EnergyMonth != 120500 {
+			t.Errorf("Unexpected status %+v", status)
+		}
+	}
+	expected := []string{
+		"eu.semsportal.com/api/v2/Common/CrossLogin",
+		"eu.semsportal.com/api/v2/HistoryData/QueryPowerStationByHistory",
+		"eu.semsportal.com/api/v2/PowerStation/GetMonitorDetailByPowerstationId",
+		"eu.semsportal.com/api/v2/PowerStation/GetMonitorDetailByPowerstationId",
+	}
+	if strings.Join(requests, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected a single login, got %v", requests)
+	}
+
+	// An expired token leads to a new login and a retry.
+	expired = true
+	requests = nil
+	if _, err := p.GetSolarStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 3 || !strings.HasSuffix(requests[1], "CrossLogin") {
+		t.Errorf("Expected a re-login, got %v", requests)
+	}
+}
+
+func TestSemsLoginError(t *testing.T) {
+	var requests []string
+	expired := false
+	p, err := NewSemsProvider("test", "user", "wrong", "", "station-1", 60, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetTransport(semsTransport(t, &requests, &expired))
+
+	_, err = p.GetSolarStatus()
+	var loginErr *LoginError
+	if !errors.As(err, &loginErr) {
+		t.Fatalf("Expected a LoginError, got %v", err)
+	}
+	if requests[0] != "www.semsportal.com/api/v2/Common/CrossLogin" {
+		t.Errorf("Expected to log in at the global portal, got %s", requests[0])
+	}
+
+	// A login rejected with a status code is a login error too.
+	p.SetTransport(fakeTransport(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 401, Status: "401 Unauthorized", Body: io.NopCloser(strings.NewReader(""))}, nil
+	}))
+	if _, err = p.GetSolarStatus(); !errors.As(err, &loginErr) {
+		t.Errorf("Expected a LoginError for a rejected login, got %v", err)
+	}
+
+	if _, err := NewSemsProvider("test", "user", "secret", "mars", "", 60, nil); err == nil {
+		t.Errorf("Expected an error for an unknown region")
+	}
+}
+
+func TestSemsDetails(t *testing.T) {
+	monitor := `{"hasError":false,"code":0,"msg":"success","data":{
+		"kpi":{"pac":2100,"power":8.2,"total_power":9000.1},
+		"inverter":[{"sn":"5010KETU000W0001","name":"Garage","type":"GW5K-ET","status":1,
+			"invert_full":{"pac":2100,"eday":8.2,"etotal":9000.1,"vac1":231.5,"iac1":9.1,"fac1":50.01,"tempperature":38.4,"vpv1":350.2,"ipv1":4.1,"vpv2":340.8,"ipv2":2.0,"vpv3":0,"ipv3":0},
+			"d":{"warning":"Utility Loss"}}],
+		"powerflow":{"pv":"2100(W)","load":"900(W)","grid":"700(W)","gridStatus":-1,"bettery":"500(W)","betteryStatus":-1,"soc":64}}}`
+	p, err := NewSemsProvider("test", "user", "secret", "", "station-1", 60, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetTransport(fakeTransport(func(req *http.Request) (*http.Response, error) {
+		response := monitor
+		if strings.HasSuffix(req.URL.Path, "CrossLogin") {
+			response = `{"hasError":false,"code":0,"data":{"uid":"u1","timestamp":1,"token":"t1"}}`
+		}
+		return &http.Response{StatusCode: 200, Status: "200 OK", Body: io.NopCloser(strings.NewReader(response))}, nil
+	}))
+
+	status, err := p.GetSolarStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Financial != nil {
+		t.Errorf("Expected no financial status without income, got %+v", status.Financial)
+	}
+
+	inverters := p.Inverters()
+	if len(inverters) != 1 {
+		t.Fatalf("Expected 1 inverter, got %d", len(inverters))
+	}
+	inv := inverters[0]
+	if inv.Serial != "5010KETU000W0001" || inv.Mode != "normal" || inv.EnergyToday == nil || *inv.EnergyToday != 8200 || *inv.Temperature != 38.4 {
+		t.Errorf("Unexpected inverter %+v", inv)
+	}
+	if len(inv.Warnings) != 1 || inv.Warnings[0] != "Utility Loss" {
+		t.Errorf("Expected the warning, got %v", inv.Warnings)
+	}
+	if len(inv.Strings) != 2 || inv.Strings[1].Name != "pv2" || *inv.Strings[1].PowerNow != 340.8*2.0 || inv.Strings[1].EnergyTotal != nil {
+		t.Errorf("Expected 2 connected strings, got %+v", inv.Strings)
+	}
+
+	grid, _ := p.GetGridStatus()
+	if grid.PowerExport != 700 || grid.PowerImport != 0 || grid.EnergyImport != nil || grid.EnergyExport != nil {
+		t.Errorf("Unexpected grid status %+v", grid)
+	}
+	load, _ := p.GetLoadStatus()
+	if load.PowerNow != 900 || load.EnergyTotal != nil {
+		t.Errorf("Unexpected load status %+v", load)
+	}
+	battery, _ := p.GetBatteryStatus()
+	if battery.PowerCharge != 500 || battery.StateOfCharge == nil || *battery.StateOfCharge != 64 || battery.EnergyCharged != nil {
+		t.Errorf("Unexpected battery status %+v", battery)
+	}
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+	"github.com/rvben/solar_exporter/sun"
+)
+
+// simulatorStep is the integration step for simulated energy.
+const simulatorStep = 5 * time.Minute
+
+// simulatorCloudSlot is how long a simulated cloud condition lasts.
+const simulatorCloudSlot = 15 * time.Minute
+
+// SimulatorConfig defines a simulator site. Cloudiness between 0 and 1 sets
+// how much clouds may reduce the clear-sky output, FailureRate the fraction of
+// polls that fail. Start is the commissioning date the total counts from.
+type SimulatorConfig struct {
+	Site        string  `yaml:"site"`
+	Timeout     int     `yaml:"timeout"`
+	Lat         float64 `yaml:"lat"`
+	Lon         float64 `yaml:"lon"`
+	KWp         float64 `yaml:"kwp"`
+	Tilt        float64 `yaml:"tilt"`
+	Azimuth     float64 `yaml:"azimuth"`
+	Cloudiness  float64 `yaml:"cloudiness"`
+	FailureRate float64 `yaml:"failure_rate"`
+	Seed        int64   `yaml:"seed"`
+	Start       string  `yaml:"start"`
+}
+
+// SimulatorProvider generates realistic production data from a clear-sky
+// model, for demos and for testing dashboards and alerting. Its output only
+// depends on the configuration, the seed and the time.
+type SimulatorProvider struct {
+	site    string
+	config  SimulatorConfig
+	array   sun.Array
+	start   time.Time
+	timeout int
+	db      *models.DataBase
+	now     func() time.Time
+
+	mu       sync.Mutex
+	failures *rand.Rand
+	days     map[string]float64
+}
+
+func (p *SimulatorProvider) Site() string {
+	return p.site
+}
+
+func (p *SimulatorProvider) Timeout() int {
+	return p.timeout
+}
+
+func (p *SimulatorProvider) DB() *models.DataBase {
+	return p.db
+}
+
+func NewSimulatorProvider(site string, config SimulatorConfig, timeout int, db *models.DataBase) (*SimulatorProvider, error) {
+	if config.KWp <= 0 {
+		return nil, fmt.Errorf("%s - kwp must be positive", site)
+	}
+	if config.Cloudiness < 0 || config.Cloudiness > 1 {
+		return nil, fmt.Errorf("%s - cloudiness must be between 0 and 1", site)
+	}
+	if config.FailureRate < 0 || config.FailureRate > 1 {
+		return nil, fmt.Errorf("%s - failure_rate must be between 0 and 1", site)
+	}
+	if config.Tilt == 0 && config.Azimuth == 0 {
+		config.Tilt, config.Azimuth = 35, 180
+	}
+
+	now := time.Now()
+	start := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.Local)
+	if config.Start != "" {
+		var err error
+		start, err = time.ParseInLocation("2006-01-02", config.Start, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("%s - invalid start date [%s]: %s", site, config.Start, err)
+		}
+	}
+
+	return &SimulatorProvider{
+		site:     site,
+		config:   config,
+		array:    sun.Array{Lat: config.Lat, Lon: config.Lon, Tilt: config.Tilt, Azimuth: config.Azimuth, KWp: config.KWp},
+		start:    start,
+		timeout:  timeout,
+		db:       db,
+		now:      time.Now,
+		failures: rand.New(rand.NewSource(config.Seed)),
+		days:     map[string]float64{},
+	}, nil
+}
+
+// cloudFactor returns the fraction of clear-sky output that reaches the panels
+// at time t. It mixes a per-day and a per-slot random draw, both derived from
+// the seed so the same moment always gets the same weather.
+func (p *SimulatorProvider) cloudFactor(t time.Time) float64 {
+	if p.config.Cloudiness == 0 {
+		return 1
+	}
+	day := simulatorNoise(p.config.Seed, t.Unix()/86400)
+	slot := simulatorNoise(p.config.Seed+1, t.Unix()/int64(simulatorCloudSlot.Seconds()))
+	return 1 - p.config.Cloudiness*(day+slot)/2
+}
+
+// simulatorNoise returns a value in [0, 1) derived from seed and n with the
+// SplitMix64 mixer, which is far cheaper than seeding a new math/rand source
+// for every integration step.
+func simulatorNoise(seed, n int64) float64 {
+	z := uint64(seed)*0x9E3779B97F4A7C15 + uint64(n)
+	z = (z ^ z>>30) * 0xBF58476D1CE4E5B9
+	z = (z ^ z>>27) * 0x94D049BB133111EB
+	z ^= z >> 31
+	return float64(z>>11) / (1 << 53)
+}
+
+func (p *SimulatorProvider) power(t time.Time) float64 {
+	return p.array.Power(t) * p.cloudFactor(t)
+}
+
+func (p *SimulatorProvider) energy(from, to time.Time) float64 {
+	energy := 0.0
+	for t := from; t.Before(to); t = t.Add(simulatorStep) {
+		dt := simulatorStep
+		if t.Add(dt).After(to) {
+			dt = to.Sub(t)
+		}
+		energy += p.power(t.Add(dt/2)) * dt.Hours()
+	}
+	return energy
+}
+
+// dayEnergy returns the energy of a full past day, cached by date.
+func (p *SimulatorProvider) dayEnergy(day time.Time) float64 {
+	key := day.Format("2006-01-02")
+	if v, ok := p.days[key]; ok {
+		return v
+	}
+	v := p.energy(day, day.AddDate(0, 0, 1))
+	p.days[key] = v
+	return v
+}
+
+// energySince returns the energy from the start of the given day, or the
+// commissioning date if later, up to now.
+func (p *SimulatorProvider) energySince(from, start, midnight time.Time, today float64) float64 {
+	if from.Before(start) {
+		from = start
+	}
+	energy := today
+	for day := from; day.Before(midnight); day = day.AddDate(0, 0, 1) {
+		energy += p.dayEnergy(day)
+	}
+	return energy
+}
+
+func (p *SimulatorProvider) GetSolarStatus() (*models.SolarStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.config.FailureRate > 0 && p.failures.Float64() < p.config.FailureRate {
+		return nil, fmt.Errorf("simulated failure for site [%s]", p.site)
+	}
+
+	now := p.now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	yearStart := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
+	start := time.Date(p.start.Year(), p.start.Month(), p.start.Day(), 0, 0, 0, 0, now.Location())
+
+	energyToday := p.energy(midnight, now)
+	energyMonth := p.energySince(monthStart, start, midnight, energyToday)
+	energyYear := p.energySince(yearStart, start, midnight, energyToday)
+	energyTotal := p.energySince(start, start, midnight, energyToday)
+	powerNow := p.power(now)
+
+	status := models.SolarStatus{EnergyToday: energyToday, EnergyMonth: energyMonth, EnergyYear: energyYear, EnergyTotal: energyTotal, PowerNow: powerNow}
+	return &status, nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestSimulator(t *testing.T, config SimulatorConfig, now time.Time) *SimulatorProvider {
+	provider, err := NewSimulatorProvider("Site", config, 10, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	provider.now = func() time.Time { return now }
+	return provider
+}
+
+func TestSimulatorGetSolarStatus(t *testing.T) {
+	config := SimulatorConfig{Lat: 52.37, Lon: 4.89, KWp: 4, Tilt: 35, Azimuth: 180, Cloudiness: 0.5, Seed: 42, Start: "2023-03-01"}
+	noon := time.Date(2024, 6, 21, 13, 40, 0, 0, time.FixedZone("CEST", 2*3600))
+
+	status, err := newTestSimulator(t, config, noon).GetSolarStatus()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.PowerNow <= 0 || status.PowerNow > 4000 {
+		t.Errorf("Expected PowerNow between 0 and 4000, got %f", status.PowerNow)
+	}
+	if !(status.EnergyToday > 0 && status.EnergyToday < status.EnergyMonth && status.EnergyMonth < status.EnergyYear && status.EnergyYear < status.EnergyTotal) {
+		t.Errorf("Expected increasing energy counters, got %+v", status)
+	}
+
+	// The same seed and time give the same status; another seed does not.
+	again, _ := newTestSimulator(t, config, noon).GetSolarStatus()
+	if !reflect.DeepEqual(again, status) {
+		t.Errorf("Expected %+v, got %+v", status, again)
+	}
+	config.Seed = 7
+	other, _ := newTestSimulator(t, config, noon).GetSolarStatus()
+	if other.PowerNow == status.PowerNow {
+		t.Errorf("Expected a different PowerNow for another seed")
+	}
+
+	night, _ := newTestSimulator(t, config, noon.Add(10*time.Hour)).GetSolarStatus()
+	if night.PowerNow != 0 {
+		t.Errorf("Expected no power at night, got %f", night.PowerNow)
+	}
+}
+
+func TestSimulatorFailures(t *testing.T) {
+	config := SimulatorConfig{Lat: 52.37, Lon: 4.89, KWp: 4, FailureRate: 1}
+	if _, err := newTestSimulator(t, config, time.Now()).GetSolarStatus(); err == nil {
+		t.Errorf("Expected simulated failure")
+	}
+	if _, err := NewSimulatorProvider("Site", SimulatorConfig{KWp: 4, Cloudiness: 2}, 10, nil); err == nil {
+		t.Errorf("Expected error for invalid cloudiness")
+	}
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+const smaMulticastAddress = "239.12.255.254:9522"
+
+// smaProtocolEmeter is the protocol id of energy meter telegrams.
+const smaProtocolEmeter = 0x6069
+
+// smaStaleAfter is how long the latest telegram of a meter stays valid. Meters
+// broadcast once per second, so a minute without data means it is gone.
+const smaStaleAfter = time.Minute
+
+// smaTelegram holds the active power and energy readings of one energy meter
+// telegram. Powers are in W and counters in Wh.
+type smaTelegram struct {
+	Serial       uint32
+	PowerImport  float64
+	PowerExport  float64
+	EnergyImport float64
+	EnergyExport float64
+	Received     time.Time
+}
+
+// parseSMATelegram decodes a Speedwire energy meter telegram. Only the total
+// active power (OBIS 1.4.0/2.4.0) and energy (1.8.0/2.8.0) channels are kept;
+// all other channels are skipped.
+func parseSMATelegram(packet []byte) (*smaTelegram, error) {
+	if len(packet) < 28 || string(packet[0:4]) != "SMA\x00" {
+		return nil, fmt.Errorf("not a Speedwire packet")
+	}
+	if protocol := binary.BigEndian.Uint16(packet[16:18]); protocol != smaProtocolEmeter {
+		return nil, fmt.Errorf("unsupported protocol id 0x%04x", protocol)
+	}
+	end := 16 + int(binary.BigEndian.Uint16(packet[12:14]))
+	if end > len(packet) {
+		return nil, fmt.Errorf("truncated packet: %d of %d bytes", len(packet), end)
+	}
+
+	t := &smaTelegram{Serial: binary.BigEndian.Uint32(packet[20:24])}
+	for pos := 28; pos+4 <= end; {
+		channel, index, size := packet[pos], packet[pos+1], int(packet[pos+2])
+		pos += 4
+		if channel == 0 && index == 0 && size == 0 {
+			break
+		}
+		// The software version entry has no size but carries 4 bytes.
+		if channel == 0x90 {
+			size = 4
+		}
+		if pos+size > end {
+			return nil, fmt.Errorf("truncated OBIS entry %d.%d", index, size)
+		}
+		if channel == 0 {
+			switch size {
+			case 4:
+				value := float64(binary.BigEndian.Uint32(packet[pos:pos+4])) / 10 // 0.1 W
+				switch index {
+				case 1:
+					t.PowerImport = value
+				case 2:
+					t.PowerExport = value
+				}
+			case 8:
+				value := float64(binary.BigEndian.Uint64(packet[pos:pos+8])) / 3600 // Ws
+				switch index {
+				case 1:
+					t.EnergyImport = value
+				case 2:
+					t.EnergyExport = value
+				}
+			}
+		}
+		pos += size
+	}
+	return t, nil
+}
+
+// SMAProvider listens to the multicast telegrams of an SMA Sunny Home Manager
+// or Energy Meter. The grid meter supplies the grid readings and the optional
+// PV meter, which sees the inverter output as export, the solar readings.
+type SMAProvider struct {
+	site      string
+	iface     string
+	gridMeter uint32
+	pvMeter   uint32
+	timeout   int
+	db        *models.DataBase
+
+	mu       sync.Mutex
+	latest   map[uint32]*smaTelegram
+	day      string
+	dayStart float64
+}
+
+func (p *SMAProvider) Site() string {
+	return p.site
+}
+
+func (p *SMAProvider) Timeout() int {
+	return p.timeout
+}
+
+func (p *SMAProvider) DB() *models.DataBase {
+	return p.db
+}
+
+func NewSMAProvider(site, iface string, gridMeter, pvMeter uint32, timeout int, db *models.DataBase) *SMAProvider {
+	return &SMAProvider{site: site, iface: iface, gridMeter: gridMeter, pvMeter: pvMeter, timeout: timeout, db: db, latest: map[uint32]*smaTelegram{}}
+}
+
+// Listen joins the Speedwire multicast group and keeps the latest telegram of
+// each configured meter until the connection fails.
+func (p *SMAProvider) Listen() error {
+	var iface *net.Interface
+	if p.iface != "" {
+		var err error
+		iface, err = net.InterfaceByName(p.iface)
+		if err != nil {
+			return fmt.Errorf("could not find interface [%s]: %s", p.iface, err)
+		}
+	}
+	addr, err := net.ResolveUDPAddr("udp4", smaMulticastAddress)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", iface, addr)
+	if err != nil {
+		return fmt.Errorf("could not join multicast group [%s]: %s", smaMulticastAddress, err)
+	}
+
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, 1500)
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				log.Printf("%s - Stopped listening for SMA telegrams: %s", p.site, err)
+				return
+			}
+			p.handle(buf[:n])
+		}
+	}()
+	return nil
+}
+
+func (p *SMAProvider) handle(packet []byte) {
+	t, err := parseSMATelegram(packet)
+	if err != nil {
+		return
+	}
+	if t.Serial != p.gridMeter && t.Serial != p.pvMeter {
+		return
+	}
+	t.Received = time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.latest[t.Serial] = t
+}
+
+func (p *SMAProvider) telegram(serial uint32) (*smaTelegram, error) {
+	t, ok := p.latest[serial]
+	if !ok {
+		return nil, fmt.Errorf("no telegram received from meter [%d]", serial)
+	}
+	if time.Since(t.Received) > smaStaleAfter {
+		return nil, fmt.Errorf("no telegram received from meter [%d] since %s", serial, t.Received.Format(time.RFC3339))
+	}
+	return t, nil
+}
+
+// HasProduction returns whether a PV meter is configured.
+func (p *SMAProvider) HasProduction() bool {
+	return p.pvMeter != 0
+}
+
+func (p *SMAProvider) GetSolarStatus() (*models.SolarStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pvMeter == 0 {
+		return &models.SolarStatus{}, nil
+	}
+	t, err := p.telegram(p.pvMeter)
+	if err != nil {
+		return nil, err
+	}
+
+	// The meter only has a lifetime counter; today's energy is measured from
+	// the first reading of the day, continuing from the stored value after a
+	// restart.
+	today := time.Now().Format("2006-01-02")
+	if p.day != today {
+		p.day = today
+		p.dayStart = t.EnergyExport
+		if p.db != nil {
+			saved, err := p.db.GetDailyValue(today)
+			if err != nil {
+				return nil, err
+			}
+			p.dayStart -= saved
+		}
+	}
+
+	status := models.SolarStatus{EnergyToday: t.EnergyExport - p.dayStart, EnergyTotal: t.EnergyExport, PowerNow: t.PowerExport}
+	return &status, nil
+}
+
+func (p *SMAProvider) GetGridStatus() (*models.GridStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.gridMeter == 0 {
+		return nil, nil
+	}
+	t, err := p.telegram(p.gridMeter)
+	if err != nil {
+		return nil, err
+	}
+	status := models.GridStatus{PowerImport: t.PowerImport, PowerExport: t.PowerExport, EnergyImport: &t.EnergyImport, EnergyExport: &t.EnergyExport}
+	return &status, nil
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+)
+
+func readHexFixture(t *testing.T, name string) []byte {
+	raw, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("Error reading fixture: %v", err)
+	}
+	packet, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		t.Fatalf("Error decoding fixture: %v", err)
+	}
+	return packet
+}
+
+func TestParseSMATelegram(t *testing.T) {
+	telegram, err := parseSMATelegram(readHexFixture(t, "sma_emeter_grid.hex"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if telegram.Serial != 1900100001 {
+		t.Errorf("Expected serial 1900100001, got %d", telegram.Serial)
+	}
+	if telegram.PowerImport != 350.5 {
+		t.Errorf("Expected PowerImport 350.5, got %f", telegram.PowerImport)
+	}
+	if telegram.PowerExport != 0 {
+		t.Errorf("Expected PowerExport 0, got %f", telegram.PowerExport)
+	}
+	if telegram.EnergyImport != 1234500 {
+		t.Errorf("Expected EnergyImport 1234500, got %f", telegram.EnergyImport)
+	}
+	if telegram.EnergyExport != 2000000 {
+		t.Errorf("Expected EnergyExport 2000000, got %f", telegram.EnergyExport)
+	}
+
+	if _, err := parseSMATelegram([]byte("SMA\x00garbage")); err == nil {
+		t.Errorf("Expected error for truncated packet")
+	}
+}
+
+func TestParseSMAHomeManagerTelegram(t *testing.T) {
+	// A full Sunny Home Manager 2.0 telegram in the order the device sends
+	// it: the totals, the power factor and frequency, the channels of each
+	// phase and the software version.
+	packet := readHexFixture(t, "sma_home_manager2.hex")
+	if len(packet) != 608 {
+		t.Fatalf("Expected a 608 byte telegram, got %d", len(packet))
+	}
+	telegram, err := parseSMATelegram(packet)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if telegram.Serial != 3004906149 {
+		t.Errorf("Expected serial 3004906149, got %d", telegram.Serial)
+	}
+	// The per-phase channels must not overwrite the totals.
+	if telegram.PowerImport != 0 || telegram.PowerExport != 1872.3 {
+		t.Errorf("Expected the total active powers, got %f and %f", telegram.PowerImport, telegram.PowerExport)
+	}
+	if telegram.EnergyImport != 66715644237.0/3600 || telegram.EnergyExport != 29531406115.0/3600 {
+		t.Errorf("Expected the total active energies, got %f and %f", telegram.EnergyImport, telegram.EnergyExport)
+	}
+}
+
+func TestSMAProvider(t *testing.T) {
+	provider := NewSMAProvider("Site", "", 1900100001, 1900100002, 10, nil)
+	if _, err := provider.GetSolarStatus(); err == nil {
+		t.Errorf("Expected error before any telegram was received")
+	}
+
+	provider.handle(readHexFixture(t, "sma_emeter_grid.hex"))
+	provider.handle(readHexFixture(t, "sma_emeter_pv.hex"))
+
+	status, err := provider.GetSolarStatus()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.PowerNow != 2500 {
+		t.Errorf("Expected PowerNow 2500, got %f", status.PowerNow)
+	}
+	if status.EnergyTotal != 5000000 {
+		t.Errorf("Expected EnergyTotal 5000000, got %f", status.EnergyTotal)
+	}
+	if status.EnergyToday != 0 {
+		t.Errorf("Expected EnergyToday 0, got %f", status.EnergyToday)
+	}
+
+	grid, err := provider.GetGridStatus()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if grid.PowerImport != 350.5 || grid.EnergyExport == nil || *grid.EnergyExport != 2000000 {
+		t.Errorf("Unexpected grid status: %+v", grid)
+	}
+}
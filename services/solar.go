@@ -1,6 +1,10 @@
 package services
 
-import "github.com/rvben/solar_exporter/models"
+import (
+	"log"
+
+	"github.com/rvben/solar_exporter/models"
+)
 
 type SolarStatusProvider interface {
 	GetSolarStatus() (*models.SolarStatus, error)
@@ -8,3 +12,132 @@ type SolarStatusProvider interface {
 	Timeout() int
 	DB() *models.DataBase
 }
+
+// GridStatusProvider is implemented by providers that also measure the grid
+// connection, such as energy meters.
+type GridStatusProvider interface {
+	GetGridStatus() (*models.GridStatus, error)
+}
+
+type siteWithGrid struct {
+	SolarStatusProvider
+	grid GridStatusProvider
+}
+
+// GetSolarStatus returns the status of the site without its own grid
+// readings; those of the attached meter take precedence and are read by Status.
+func (s *siteWithGrid) GetSolarStatus() (*models.SolarStatus, error) {
+	status, err := s.SolarStatusProvider.GetSolarStatus()
+	if err != nil {
+		return nil, err
+	}
+	status.Grid = nil
+	return status, nil
+}
+
+func (s *siteWithGrid) GetGridStatus() (*models.GridStatus, error) {
+	return s.grid.GetGridStatus()
+}
+
+// Unwrap returns the site the grid readings are attached to, so the optional
+// readings it reports are found through As.
+func (s *siteWithGrid) Unwrap() SolarStatusProvider {
+	return s.SolarStatusProvider
+}
+
+// WithGrid attaches the grid readings of a separate meter to a site.
+func WithGrid(p SolarStatusProvider, grid GridStatusProvider) SolarStatusProvider {
+	return &siteWithGrid{SolarStatusProvider: p, grid: grid}
+}
+
+// As returns p as a T, looking through the providers that wrap another
+// provider, such as a site with an attached grid meter.
+func As[T any](p SolarStatusProvider) (T, bool) {
+	for p != nil {
+		if t, ok := p.(T); ok {
+			return t, true
+		}
+		w, ok := p.(interface{ Unwrap() SolarStatusProvider })
+		if !ok {
+			break
+		}
+		p = w.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// ProductionProvider is implemented by providers that may measure no
+// production at all, such as meters. Sites without production have no power,
+// energy or yield to export or save.
+type ProductionProvider interface {
+	HasProduction() bool
+}
+
+// HasProduction returns whether p measures production.
+func HasProduction(p SolarStatusProvider) bool {
+	if pp, ok := As[ProductionProvider](p); ok {
+		return pp.HasProduction()
+	}
+	return true
+}
+
+// InverterStatusProvider is implemented by providers that report readings per
+// inverter. Inverters returns the inverters seen by the last GetSolarStatus.
+type InverterStatusProvider interface {
+	Inverters() []models.InverterStatus
+}
+
+// BatteryStatusProvider is implemented by providers that report the state of
+// home batteries. A nil status means the site has no battery.
+type BatteryStatusProvider interface {
+	GetBatteryStatus() (*models.BatteryStatus, error)
+}
+
+// LoadStatusProvider is implemented by providers that measure the consumption
+// of a site. A nil status means the site has no consumption meter.
+type LoadStatusProvider interface {
+	GetLoadStatus() (*models.LoadStatus, error)
+}
+
+// QuotaProvider is implemented by providers with a daily API budget.
+// QuotaRemaining returns the calls left today, and false when no quota is
+// tracked.
+type QuotaProvider interface {
+	QuotaRemaining() (int, bool)
+}
+
+// Status returns the status of p, completed with the optional readings p
+// reports through the interfaces above when GetSolarStatus left them out.
+// Failing optional readings are logged rather than failing the status.
+func Status(p SolarStatusProvider) (*models.SolarStatus, error) {
+	status, err := p.GetSolarStatus()
+	if err != nil {
+		return nil, err
+	}
+	if i, ok := As[InverterStatusProvider](p); ok && status.Inverters == nil {
+		status.Inverters = i.Inverters()
+	}
+	if g, ok := As[GridStatusProvider](p); ok && status.Grid == nil {
+		grid, err := g.GetGridStatus()
+		if err != nil {
+			log.Printf("%s - Could not retrieve grid status: %s", p.Site(), err)
+		}
+		status.Grid = grid
+	}
+	if l, ok := As[LoadStatusProvider](p); ok && status.Load == nil {
+		load, err := l.GetLoadStatus()
+		if err != nil {
+			log.Printf("%s - Could not retrieve load status: %s", p.Site(), err)
+		}
+		status.Load = load
+	}
+	if b, ok := As[BatteryStatusProvider](p); ok && status.Battery == nil {
+		battery, err := b.GetBatteryStatus()
+		if err != nil {
+			log.Printf("%s - Could not retrieve battery status: %s", p.Site(), err)
+		}
+		status.Battery = battery
+	}
+	return status, nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+type fakeSite struct {
+	status  models.SolarStatus
+	battery *models.BatteryStatus
+}
+
+func (s *fakeSite) GetSolarStatus() (*models.SolarStatus, error) {
+	status := s.status
+	return &status, nil
+}
+func (s *fakeSite) Site() string         { return "fake" }
+func (s *fakeSite) Timeout() int         { return 10 }
+func (s *fakeSite) DB() *models.DataBase { return nil }
+
+func (s *fakeSite) GetBatteryStatus() (*models.BatteryStatus, error) {
+	return s.battery, nil
+}
+
+type fakeMeter struct {
+	grid  *models.GridStatus
+	err   error
+	reads int
+}
+
+func (m *fakeMeter) GetGridStatus() (*models.GridStatus, error) {
+	m.reads++
+	return m.grid, m.err
+}
+
+func TestStatus(t *testing.T) {
+	site := &fakeSite{status: models.SolarStatus{PowerNow: 100}}
+	status, err := Status(site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Battery != nil || status.Grid != nil || status.Load != nil {
+		t.Errorf("Expected no optional readings, got %+v", status)
+	}
+
+	site.battery = &models.BatteryStatus{}
+	status, _ = Status(site)
+	if status.Battery == nil {
+		t.Errorf("Expected an empty battery to be reported")
+	}
+
+	// Readings set by the provider itself are kept.
+	site.status.Battery = &models.BatteryStatus{PowerCharge: 80}
+	status, _ = Status(site)
+	if status.Battery.PowerCharge != 80 {
+		t.Errorf("Expected the battery of the status, got %+v", status.Battery)
+	}
+}
+
+func TestWithGrid(t *testing.T) {
+	site := &fakeSite{status: models.SolarStatus{PowerNow: 100, Grid: &models.GridStatus{PowerImport: 1}}}
+	meter := &fakeMeter{grid: &models.GridStatus{PowerImport: 250}}
+
+	status, err := Status(WithGrid(site, meter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.PowerNow != 100 || status.Grid.PowerImport != 250 {
+		t.Errorf("Expected the readings of the meter, got %+v", status.Grid)
+	}
+	if _, ok := As[BatteryStatusProvider](WithGrid(site, meter)); !ok {
+		t.Errorf("Expected the battery of the site to stay available")
+	}
+	site.battery = &models.BatteryStatus{PowerCharge: 80}
+	if status, _ := Status(WithGrid(site, meter)); status.Battery == nil || status.Battery.PowerCharge != 80 {
+		t.Errorf("Expected the battery of the site, got %+v", status.Battery)
+	}
+	if _, ok := As[QuotaProvider](WithGrid(site, meter)); ok {
+		t.Errorf("Expected no quota for a site without one")
+	}
+	// A failing meter is read once per status and leaves the grid out.
+	failing := &fakeMeter{err: fmt.Errorf("no telegram")}
+	status, err = Status(WithGrid(site, failing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failing.reads != 1 || status.Grid != nil {
+		t.Errorf("Expected a single read and no grid, got %d reads and %+v", failing.reads, status.Grid)
+	}
+}
+
+func TestHasProduction(t *testing.T) {
+	site := &fakeSite{}
+	if !HasProduction(site) {
+		t.Errorf("Expected a site to produce by default")
+	}
+	if HasProduction(NewP1Provider("p1", "/dev/null", 10, nil)) {
+		t.Errorf("Expected a P1 meter to report no production")
+	}
+	if HasProduction(WithGrid(NewSMAProvider("sma", "", 1, 0, 10, nil), &fakeMeter{})) {
+		t.Errorf("Expected an SMA meter without a PV meter to report no production")
+	}
+	if !HasProduction(WithGrid(NewSMAProvider("sma", "", 1, 2, 10, nil), &fakeMeter{})) {
+		t.Errorf("Expected an SMA meter with a PV meter to report production")
+	}
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rvben/solar_exporter/sun"
+)
+
+// solarEdgeDaylightStart and solarEdgeDaylightEnd bound the part of the day,
+// as offsets from local midnight, over which the daily budget is spread for
+// sites without a location.
+const (
+	solarEdgeDaylightStart = 5 * time.Hour
+	solarEdgeDaylightEnd   = 22 * time.Hour
+)
+
+// solarEdgeDefaultBackoff is how long the API is left alone after a 429
+// without a Retry-After header.
+const solarEdgeDefaultBackoff = 15 * time.Minute
+
+// SolarEdgeQuota tracks the calls made with a single API key. SolarEdge limits
+// the calls per key and per day, so all sites sharing a key share one quota.
+// The usage is saved to a file so a restart does not reset it.
+type SolarEdgeQuota struct {
+	path  string
+	limit int
+	now   func() time.Time
+
+	mu        sync.Mutex
+	state     solarEdgeQuotaState
+	polls     map[string]solarEdgePoll
+	locations map[string][2]float64
+	plans     map[string]solarEdgePlan
+}
+
+// solarEdgePlan is the number of calls per day a site plans besides its
+// equipment telemetry, and whether it wants equipment telemetry.
+type solarEdgePlan struct {
+	calls     int
+	equipment bool
+}
+
+// solarEdgePoll is the last poll of a site and whether it was in daylight.
+type solarEdgePoll struct {
+	at       time.Time
+	daylight bool
+}
+
+type solarEdgeQuotaState struct {
+	Day          string    `json:"day"`
+	Used         int       `json:"used"`
+	BlockedUntil time.Time `json:"blocked_until"`
+}
+
+// NewSolarEdgeQuota returns a quota of limit calls per day, saved to path. An
+// empty path keeps the usage in memory only.
+func NewSolarEdgeQuota(path string, limit int) *SolarEdgeQuota {
+	q := &SolarEdgeQuota{path: path, limit: limit, now: time.Now, polls: map[string]solarEdgePoll{}, locations: map[string][2]float64{}, plans: map[string]solarEdgePlan{}}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			if err := json.Unmarshal(data, &q.state); err != nil {
+				log.Printf("Ignoring invalid SolarEdge quota file [%s]: %s", path, err)
+				q.state = solarEdgeQuotaState{}
+			}
+		} else if !os.IsNotExist(err) {
+			log.Printf("Could not read SolarEdge quota file [%s]: %s", path, err)
+		}
+	}
+	return q
+}
+
+// SolarEdgeQuotaPath returns the file in dir that holds the usage of apiKey.
+// The key is hashed so it does not end up in the file name.
+func SolarEdgeQuotaPath(dir, apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return fmt.Sprintf("%s/solaredge-%s.json", dir, hex.EncodeToString(sum[:8]))
+}
+
+// Register adds a site to the quota, so the budget is split between the sites.
+func (q *SolarEdgeQuota) Register(site string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.polls[site]; !ok {
+		q.polls[site] = solarEdgePoll{}
+	}
+}
+
+// Plan records the calls per day site makes besides its equipment telemetry
+// and whether it collects equipment telemetry, and returns the total planned
+// calls of all sites.
+func (q *SolarEdgeQuota) Plan(site string, calls int, equipment bool) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.plans[site] = solarEdgePlan{calls: calls, equipment: equipment}
+	total := 0
+	for _, p := range q.plans {
+		total += p.calls
+	}
+	return total
+}
+
+// EquipmentBudget returns the calls per day left for the equipment telemetry
+// of a single site: what the planned calls of all sites leave of the limit,
+// split between the sites that collect equipment telemetry.
+func (q *SolarEdgeQuota) EquipmentBudget() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	left, sites := q.limit, 0
+	for _, p := range q.plans {
+		left -= p.calls
+		if p.equipment {
+			sites++
+		}
+	}
+	if left <= 0 || sites == 0 {
+		return 0
+	}
+	return left / sites
+}
+
+// SetLocation spreads the polls of site over its hours of daylight instead of
+// the fixed hours used without a location.
+func (q *SolarEdgeQuota) SetLocation(site string, lat, lon float64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.locations[site] = [2]float64{lat, lon}
+}
+
+// daylight returns the daylight of site that now falls in, or else the next
+// one. The caller holds mu.
+func (q *SolarEdgeQuota) daylight(site string, now time.Time) (time.Time, time.Time) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if loc, ok := q.locations[site]; ok {
+		if start, end, ok := sun.NextDaylight(now, loc[0], loc[1], 0); ok {
+			return start, end
+		}
+		// Midnight sun or polar night.
+		if sun.PositionAt(now, loc[0], loc[1]).Elevation > 0 {
+			return midnight, midnight.AddDate(0, 0, 1)
+		}
+		return midnight.AddDate(0, 0, 1), midnight.AddDate(0, 0, 1)
+	}
+	start := midnight.Add(solarEdgeDaylightStart)
+	end := midnight.Add(solarEdgeDaylightEnd)
+	if !now.Before(end) {
+		start, end = start.AddDate(0, 0, 1), end.AddDate(0, 0, 1)
+	}
+	return start, end
+}
+
+// rollover resets the usage at the start of a new day. The caller holds mu.
+func (q *SolarEdgeQuota) rollover() {
+	day := q.now().Format("2006-01-02")
+	if q.state.Day != day {
+		q.state.Day = day
+		q.state.Used = 0
+	}
+}
+
+func (q *SolarEdgeQuota) save() {
+	if q.path == "" {
+		return
+	}
+	data, _ := json.Marshal(q.state)
+	if err := os.WriteFile(q.path, data, 0644); err != nil {
+		log.Printf("Could not write SolarEdge quota file [%s]: %s", q.path, err)
+	}
+}
+
+// Remaining returns the number of calls left today.
+func (q *SolarEdgeQuota) Remaining() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	if q.state.Used >= q.limit {
+		return 0
+	}
+	return q.limit - q.state.Used
+}
+
+// Use records calls made to the API.
+func (q *SolarEdgeQuota) Use(calls int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	q.state.Used += calls
+	q.save()
+}
+
+// BlockedUntil returns until when the API asked not to be called, or the zero
+// time when it did not.
+func (q *SolarEdgeQuota) BlockedUntil() time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.now().After(q.state.BlockedUntil) {
+		return time.Time{}
+	}
+	return q.state.BlockedUntil
+}
+
+// Throttled handles a 429 response by blocking all calls until the time given
+// by its Retry-After header, in seconds or as a date.
+func (q *SolarEdgeQuota) Throttled(h http.Header) time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.now()
+	until := now.Add(solarEdgeDefaultBackoff)
+	if retry := h.Get("Retry-After"); retry != "" {
+		if seconds, err := strconv.Atoi(retry); err == nil {
+			until = now.Add(time.Duration(seconds) * time.Second)
+		} else if date, err := http.ParseTime(retry); err == nil {
+			until = date
+		}
+	}
+	q.state.BlockedUntil = until
+	q.save()
+	return until
+}
+
+// Allow reports whether site may poll now, making calls API calls. The calls
+// left today are split evenly between the sites and spread over the rest of
+// the daylight, or the rest of the day when the daylight of the site runs past
+// midnight; after dark every site gets one last poll to collect the final
+// totals of the day.
+func (q *SolarEdgeQuota) Allow(site string, calls int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+
+	now := q.now()
+	if now.Before(q.state.BlockedUntil) {
+		return false
+	}
+	remaining := q.limit - q.state.Used
+	if remaining < calls {
+		return false
+	}
+
+	last := q.polls[site]
+	start, end := q.daylight(site, now)
+	daylight := !now.Before(start)
+
+	allowed := false
+	if !daylight {
+		allowed = last.at.IsZero() || last.daylight
+	} else {
+		sites := len(q.polls)
+		if sites == 0 {
+			sites = 1
+		}
+		// The budget resets at midnight.
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1)
+		if midnight.Before(end) {
+			end = midnight
+		}
+		// Keep one poll per site in reserve for the poll after dark.
+		polls := math.Floor(float64(remaining-sites*calls) / float64(sites*calls))
+		if polls < 1 {
+			allowed = last.at.Before(start)
+		} else {
+			interval := time.Duration(float64(end.Sub(now)) / polls)
+			allowed = now.Sub(last.at) >= interval
+		}
+	}
+	if allowed {
+		q.polls[site] = solarEdgePoll{at: now, daylight: daylight}
+	}
+	return allowed
+}
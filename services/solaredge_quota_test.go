@@ -0,0 +1,175 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSolarEdgeQuotaAllow(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	q := NewSolarEdgeQuota("", 300)
+	q.now = func() time.Time { return now }
+	q.Register("a")
+	q.Register("b")
+
+	if !q.Allow("a", 1) || !q.Allow("b", 1) {
+		t.Fatal("Expected the first poll of each site to be allowed")
+	}
+	q.Use(2)
+
+	// 298 calls left for 2 sites over 10 hours, with one poll each in reserve:
+	// a poll every 10h/148, a little over 4 minutes.
+	now = now.Add(4 * time.Minute)
+	if q.Allow("a", 1) {
+		t.Errorf("Expected a poll after 4 minutes to be skipped")
+	}
+	now = now.Add(time.Minute)
+	if !q.Allow("a", 1) {
+		t.Errorf("Expected a poll after 5 minutes to be allowed")
+	}
+
+	// After dark each site gets a single poll.
+	now = time.Date(2024, 6, 1, 22, 30, 0, 0, time.Local)
+	if !q.Allow("a", 1) {
+		t.Errorf("Expected the poll after dark to be allowed")
+	}
+	now = now.Add(time.Hour)
+	if q.Allow("a", 1) {
+		t.Errorf("Expected a second poll after dark to be skipped")
+	}
+
+	// Exhausting the budget stops all polls until the next day.
+	q.Use(298)
+	if q.Allow("b", 1) {
+		t.Errorf("Expected no polls without budget")
+	}
+	now = time.Date(2024, 6, 2, 8, 0, 0, 0, time.Local)
+	if q.Remaining() != 300 || !q.Allow("b", 1) {
+		t.Errorf("Expected the budget to reset on a new day")
+	}
+}
+
+func TestSolarEdgeQuotaPersisted(t *testing.T) {
+	path := SolarEdgeQuotaPath(t.TempDir(), "key")
+	if strings.Contains(filepath.Base(path), "key") {
+		t.Errorf("Expected the API key to be hashed in [%s]", path)
+	}
+	q := NewSolarEdgeQuota(path, 300)
+	q.Use(42)
+
+	restarted := NewSolarEdgeQuota(path, 300)
+	if got := restarted.Remaining(); got != 258 {
+		t.Errorf("Expected 258 calls left after a restart, got %d", got)
+	}
+}
+
+func TestSolarEdgeTooManyRequests(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	q := NewSolarEdgeQuota("", 300)
+	q.now = func() time.Time { return now }
+
+	calls := 0
+	p := NewSolarEdgeProvider("test", "key", "1234", 600, nil)
+	p.SetQuota(q)
+	p.SetTransport(fakeTransport(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: 429, Status: "429 Too Many Requests", Header: http.Header{"Retry-After": {"120"}}, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}))
+
+	if _, err := p.GetSolarStatus(); err == nil {
+		t.Fatal("Expected an error for a 429 response")
+	}
+	if until := q.BlockedUntil(); !until.Equal(now.Add(2 * time.Minute)) {
+		t.Errorf("Expected to be blocked for 2 minutes, got %s", until)
+	}
+
+	// Further calls wait for the Retry-After without reaching the API.
+	if err := p.get("/site/1234/overview", nil, &struct{}{}); err == nil || calls != 1 {
+		t.Errorf("Expected the call to be refused while blocked, %d calls made", calls)
+	}
+	if remaining, _ := p.QuotaRemaining(); remaining != 299 {
+		t.Errorf("Expected 299 calls left, got %d", remaining)
+	}
+}
+
+func TestSolarEdgeSkippedPoll(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	q := NewSolarEdgeQuota("", 300)
+	q.now = func() time.Time { return now }
+
+	calls := 0
+	p := NewSolarEdgeProvider("test", "key", "1234", 60, nil)
+	p.SetQuota(q)
+	p.SetTransport(fakeTransport(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{"overview":{"lastDayData":{"energy":1000}}}`))}, nil
+	}))
+
+	if _, err := p.GetSolarStatus(); err != nil {
+		t.Fatal(err)
+	}
+	// A skipped poll is not a reading, so it must not repeat the last one.
+	status, err := p.GetSolarStatus()
+	if !errors.Is(err, ErrPollSkipped) || status != nil || calls != 1 {
+		t.Errorf("Expected a skipped poll without a status, got %+v %v after %d calls", status, err, calls)
+	}
+}
+
+func TestSolarEdgeQuotaLocation(t *testing.T) {
+	// The daylight of Sydney spans midnight UTC.
+	now := time.Date(2024, 12, 21, 2, 0, 0, 0, time.UTC)
+	q := NewSolarEdgeQuota("", 300)
+	q.now = func() time.Time { return now }
+	q.Register("sydney")
+	q.SetLocation("sydney", -33.87, 151.21)
+
+	if !q.Allow("sydney", 3) {
+		t.Fatal("Expected a poll at noon in Sydney to be allowed")
+	}
+	q.Use(3)
+	// 297 calls left for one site until sunset around 09:05 UTC, with one
+	// poll in reserve: a poll of 3 calls every 7h/98, about 4 minutes.
+	now = now.Add(3 * time.Minute)
+	if q.Allow("sydney", 3) {
+		t.Errorf("Expected a poll after 3 minutes to be skipped")
+	}
+	now = now.Add(2 * time.Minute)
+	if !q.Allow("sydney", 3) {
+		t.Errorf("Expected a poll after 5 minutes to be allowed")
+	}
+
+	// Sunset in Sydney is around 09:05 UTC.
+	now = time.Date(2024, 12, 21, 10, 0, 0, 0, time.UTC)
+	if !q.Allow("sydney", 3) {
+		t.Errorf("Expected the poll after dark to be allowed")
+	}
+	now = now.Add(time.Hour)
+	if q.Allow("sydney", 3) {
+		t.Errorf("Expected a second poll after dark to be skipped")
+	}
+}
+
+func TestSolarEdgeSharedEquipmentBudget(t *testing.T) {
+	// Two sites on one key, each polling the overview every 15 minutes and
+	// the inventory once a day: 2*97 calls, leaving 106 for the telemetry of
+	// both sites.
+	q := NewSolarEdgeQuota("", 300)
+	a := NewSolarEdgeProvider("a", "key", "1", 900, nil)
+	b := NewSolarEdgeProvider("b", "key", "2", 900, nil)
+	for _, p := range []*SolarEdgeProvider{a, b} {
+		p.SetQuota(q)
+		p.EnableEquipment(0)
+	}
+	if got := q.EquipmentBudget(); got != 53 {
+		t.Errorf("Expected 53 telemetry calls per site, got %d", got)
+	}
+	// 53 calls for 2 inverters is a poll every 24h/26.
+	if got := a.equipmentBudgetInterval(2); got != 24*time.Hour/26 {
+		t.Errorf("Expected %s, got %s", 24*time.Hour/26, got)
+	}
+}
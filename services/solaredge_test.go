@@ -0,0 +1,183 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+func solarEdgeTransport(calls map[string]int) fakeTransport {
+	return fakeTransport(func(req *http.Request) (*http.Response, error) {
+		calls[req.URL.Path]++
+		var body string
+		switch req.URL.Path {
+		case "/site/1234/overview":
+			body = `{"overview":{"lifeTimeData":{"energy":1000000},"lastYearData":{"energy":500000},"lastMonthData":{"energy":40000},"lastDayData":{"energy":3000},"currentPower":{"power":750}}}`
+		case "/site/1234/inventory":
+			body = `{"Inventory":{"inverters":[{"name":"Inverter 1","manufacturer":"SolarEdge","model":"SE5000H","SN":"7E1234AB-01"}]}}`
+		case "/equipment/1234/7E1234AB-01/data":
+			if req.URL.Query().Get("startTime") == "" || req.URL.Query().Get("endTime") == "" {
+				return &http.Response{StatusCode: 400, Status: "400 Bad Request", Body: io.NopCloser(strings.NewReader(""))}, nil
+			}
+			body = `{"data":{"count":2,"telemetries":[
+				{"date":"2024-06-01 11:50:00","totalActivePower":700.0,"dcVoltage":380.1,"totalEnergy":999000.0,"temperature":40.5,"inverterMode":"MPPT","L1Data":{"acCurrent":3.0,"acVoltage":231.0,"acFrequency":50.01}},
+				{"date":"2024-06-01 11:55:00","totalActivePower":750.0,"dcVoltage":381.2,"totalEnergy":1000000.0,"temperature":41.0,"inverterMode":"MPPT","L1Data":{"acCurrent":3.2,"acVoltage":232.0,"acFrequency":50.02}}]}}`
+		default:
+			return &http.Response{StatusCode: 404, Status: "404 Not Found", Body: io.NopCloser(strings.NewReader(""))}, nil
+		}
+		return &http.Response{StatusCode: 200, Status: "200 OK", Body: io.NopCloser(strings.NewReader(body))}, nil
+	})
+}
+
+func TestSolarEdgeEquipment(t *testing.T) {
+	calls := map[string]int{}
+	p := NewSolarEdgeProvider("test", "key", "1234", 600, nil)
+	p.SetTransport(solarEdgeTransport(calls))
+	p.EnableEquipment(60)
+
+	status, err := p.GetSolarStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.PowerNow != 750 {
+		t.Errorf("Expected power 750, got %f", status.PowerNow)
+	}
+
+	inverters := p.Inverters()
+	if len(inverters) != 1 {
+		t.Fatalf("Expected 1 inverter, got %d", len(inverters))
+	}
+	inv := inverters[0]
+	if inv.Serial != "7E1234AB-01" || inv.Model != "SE5000H" || inv.Mode != "MPPT" {
+		t.Errorf("Unexpected inverter details %+v", inv)
+	}
+	if inv.PowerNow == nil || *inv.PowerNow != 750 || inv.EnergyTotal == nil || *inv.EnergyTotal != 1000000 || inv.EnergyToday != nil {
+		t.Errorf("Expected the last telemetry, got %+v", inv)
+	}
+	if inv.ACVoltage == nil || *inv.ACVoltage != 232 || inv.Temperature == nil || *inv.Temperature != 41 {
+		t.Errorf("Unexpected equipment readings %+v", inv)
+	}
+
+	// 144 overview polls per day leave room for one telemetry call every
+	// 24h/155, so the 60 second interval has to be raised.
+	if p.equipmentInterval < 24*time.Hour/155 {
+		t.Errorf("Expected the interval to be raised, got %s", p.equipmentInterval)
+	}
+
+	// A second poll within the interval only calls the overview.
+	if _, err := p.GetSolarStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if calls["/site/1234/overview"] != 2 || calls["/site/1234/inventory"] != 1 || calls["/equipment/1234/7E1234AB-01/data"] != 1 {
+		t.Errorf("Unexpected API calls %v", calls)
+	}
+}
+
+func TestSolarEdgeEquipmentBudget(t *testing.T) {
+	// Polling the overview every 5 minutes uses 288 of the 300 calls and the inventory
+	// one more, leaving 5 telemetry calls for each of 2 inverters.
+	p := NewSolarEdgeProvider("test", "key", "1234", 300, nil)
+	p.EnableEquipment(0)
+	if got := p.equipmentBudgetInterval(2); got != 24*time.Hour/5 {
+		t.Errorf("Expected 4h48m, got %s", got)
+	}
+	if got := p.equipmentBudgetInterval(12); got != 0 {
+		t.Errorf("Expected no budget, got %s", got)
+	}
+}
+
+func TestSolarEdgePowerFlow(t *testing.T) {
+	calls := map[string]int{}
+	responses := map[string]string{
+		"/site/1234/overview":         `{"overview":{"lifeTimeData":{"energy":1000000},"lastDayData":{"energy":3000},"currentPower":{"power":2000}}}`,
+		"/site/1234/currentPowerFlow": `{"siteCurrentPowerFlow":{"unit":"kW","connections":[{"from":"GRID","to":"Load"},{"from":"PV","to":"Storage"}],"GRID":{"status":"Active","currentPower":0.5},"LOAD":{"status":"Active","currentPower":1.2},"PV":{"status":"Active","currentPower":2.0},"STORAGE":{"status":"Charging","currentPower":1.3,"chargeLevel":61}}}`,
+		"/site/1234/storageData":      `{"storageData":{"batteryCount":1,"batteries":[{"serialNumber":"BAT1","telemetries":[{"lifeTimeEnergyCharged":100,"lifeTimeEnergyDischarged":50},{"lifeTimeEnergyCharged":5000,"lifeTimeEnergyDischarged":4000}]}]}}`,
+		"/site/1234/meters":           `{"meterEnergyDetails":{"unit":"Wh","meters":[{"meterType":"Purchased","values":[{"date":"2024-06-01 11:00:00","value":8000},{"date":"2024-06-01 11:15:00"}]},{"meterType":"FeedIn","values":[{"date":"2024-06-01 11:00:00","value":6000}]},{"meterType":"Consumption","values":[{"date":"2024-06-01 11:00:00","value":12000}]}]}}`,
+	}
+	p := NewSolarEdgeProvider("test", "key", "1234", 900, nil)
+	p.SetTransport(fakeTransport(func(req *http.Request) (*http.Response, error) {
+		calls[req.URL.Path]++
+		return &http.Response{StatusCode: 200, Status: "200 OK", Body: io.NopCloser(strings.NewReader(responses[req.URL.Path]))}, nil
+	}))
+
+	if grid, _ := p.GetGridStatus(); grid != nil {
+		t.Errorf("Expected no grid status without the power flow")
+	}
+	p.EnablePowerFlow()
+	for i := 0; i < 2; i++ {
+		if _, err := p.GetSolarStatus(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	grid, err := p.GetGridStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grid.PowerImport != 500 || grid.PowerExport != 0 || *grid.EnergyImport != 8000 || *grid.EnergyExport != 6000 {
+		t.Errorf("Unexpected grid status %+v", grid)
+	}
+	load, _ := p.GetLoadStatus()
+	if load.PowerNow != 1200 || *load.EnergyTotal != 12000 {
+		t.Errorf("Unexpected load status %+v", load)
+	}
+	battery, _ := p.GetBatteryStatus()
+	if battery.PowerCharge != 1300 || battery.PowerDischarge != 0 || *battery.StateOfCharge != 61 || *battery.EnergyCharged != 5000 || *battery.EnergyDischarged != 4000 {
+		t.Errorf("Unexpected battery status %+v", battery)
+	}
+
+	// The counters are only fetched once per hour.
+	if calls["/site/1234/currentPowerFlow"] != 2 || calls["/site/1234/storageData"] != 1 || calls["/site/1234/meters"] != 1 {
+		t.Errorf("Unexpected API calls %v", calls)
+	}
+
+	// A failing power flow does not repeat the readings of the last one.
+	delete(responses, "/site/1234/currentPowerFlow")
+	if _, err := p.GetSolarStatus(); err != nil {
+		t.Fatal(err)
+	}
+	grid, _ = p.GetGridStatus()
+	load, _ = p.GetLoadStatus()
+	battery, _ = p.GetBatteryStatus()
+	if grid != nil || load != nil || battery != nil {
+		t.Errorf("Expected no readings after a failed power flow, got %+v %+v %+v", grid, load, battery)
+	}
+}
+
+func TestSolarEdgePollCalls(t *testing.T) {
+	p := NewSolarEdgeProvider("test", "key", "1234", 900, nil)
+	if calls := p.pollCalls(); calls != 1 {
+		t.Errorf("Expected 1 call for the overview, got %d", calls)
+	}
+	p.EnablePowerFlow()
+	p.EnableEquipment(3600)
+	p.inverters = []models.InverterStatus{{Serial: "A"}, {Serial: "B"}}
+	// Overview, power flow, storage and meters, inventory and two inverters.
+	if calls := p.pollCalls(); calls != 7 {
+		t.Errorf("Expected 7 calls with everything due, got %d", calls)
+	}
+	p.lastCounters, p.lastInventory, p.lastEquipment = time.Now(), time.Now(), time.Now()
+	if calls := p.pollCalls(); calls != 2 {
+		t.Errorf("Expected 2 calls with nothing due, got %d", calls)
+	}
+}
+
+func TestSolarEdgeEquipmentDisabled(t *testing.T) {
+	calls := map[string]int{}
+	p := NewSolarEdgeProvider("test", "key", "1234", 60, nil)
+	p.SetTransport(solarEdgeTransport(calls))
+	p.EnableEquipment(60)
+
+	if _, err := p.GetSolarStatus(); err != nil {
+		t.Fatal(err)
+	}
+	// Polling every minute leaves no budget, so the inventory is not
+	// exported without telemetry.
+	if p.equipment || len(p.Inverters()) != 0 {
+		t.Errorf("Expected equipment to be disabled without inverters, got %+v", p.Inverters())
+	}
+}
@@ -0,0 +1,45 @@
+package sun
+
+import "time"
+
+// defaultPerformanceRatio accounts for inverter, cabling, temperature and
+// soiling losses of a typical installation.
+const defaultPerformanceRatio = 0.8
+
+// Array describes a solar panel array. Tilt is in degrees from horizontal and
+// Azimuth clockwise from north; KWp is the installed peak power.
+type Array struct {
+	Lat              float64
+	Lon              float64
+	Tilt             float64
+	Azimuth          float64
+	KWp              float64
+	PerformanceRatio float64
+}
+
+// Power returns the AC power in W the array produces at time t under a clear
+// sky.
+func (a Array) Power(t time.Time) float64 {
+	pr := a.PerformanceRatio
+	if pr == 0 {
+		pr = defaultPerformanceRatio
+	}
+	p := PositionAt(t, a.Lat, a.Lon)
+	poa := PlaneOfArray(p, ClearSky(p), a.Tilt, a.Azimuth)
+	// Peak power is rated at 1000 W/m².
+	return a.KWp * poa * pr
+}
+
+// Energy returns the energy in Wh the array produces between from and to under
+// a clear sky, integrated in steps of the given duration.
+func (a Array) Energy(from, to time.Time, step time.Duration) float64 {
+	energy := 0.0
+	for t := from; t.Before(to); t = t.Add(step) {
+		dt := step
+		if t.Add(step).After(to) {
+			dt = to.Sub(t)
+		}
+		energy += a.Power(t.Add(dt/2)) * dt.Hours()
+	}
+	return energy
+}
@@ -0,0 +1,62 @@
+package sun
+
+import "time"
+
+// horizon is the elevation of the sun at sunrise and sunset, accounting for
+// refraction and the radius of the sun.
+const horizon = -0.833
+
+// Daylight returns the sunrise on the day of t, in the location of t, and the
+// first sunset after it. It returns false when the sun does not rise that
+// day, like in polar night or midnight sun.
+func Daylight(t time.Time, lat, lon float64) (sunrise, sunset time.Time, ok bool) {
+	const step = 10 * time.Minute
+	above := func(t time.Time) bool {
+		return PositionAt(t, lat, lon).Elevation > horizon
+	}
+	// crossing refines a change of above between from and to.
+	crossing := func(from, to time.Time) time.Time {
+		up := above(to)
+		for to.Sub(from) > time.Second {
+			mid := from.Add(to.Sub(from) / 2)
+			if above(mid) == up {
+				to = mid
+			} else {
+				from = mid
+			}
+		}
+		return to.Truncate(time.Second)
+	}
+
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	next := midnight.AddDate(0, 0, 1)
+	for from := midnight; from.Before(next); from = from.Add(step) {
+		if !above(from) && above(from.Add(step)) {
+			sunrise = crossing(from, from.Add(step))
+			break
+		}
+	}
+	if sunrise.IsZero() {
+		return sunrise, sunset, false
+	}
+	for from := sunrise; from.Before(sunrise.Add(24 * time.Hour)); from = from.Add(step) {
+		if above(from) && !above(from.Add(step)) {
+			return sunrise, crossing(from, from.Add(step)), true
+		}
+	}
+	return sunrise, sunset, false
+}
+
+// NextDaylight returns the daylight, widened by margin on both sides, that t
+// falls in, or else the next one. Days are those of the location of t, so the
+// daylight of a site far from it may start on the previous day. It returns
+// false when the sun does not rise and set around t.
+func NextDaylight(t time.Time, lat, lon float64, margin time.Duration) (start, end time.Time, ok bool) {
+	for day := -1; day <= 1; day++ {
+		sunrise, sunset, ok := Daylight(t.AddDate(0, 0, day), lat, lon)
+		if ok && sunset.Add(margin).After(t) {
+			return sunrise.Add(-margin), sunset.Add(margin), true
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
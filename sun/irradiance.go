@@ -0,0 +1,46 @@
+package sun
+
+import "math"
+
+// solarConstant is the extraterrestrial irradiance in W/m².
+const solarConstant = 1353
+
+// groundAlbedo is the fraction of irradiance reflected by the ground.
+const groundAlbedo = 0.2
+
+// Irradiance holds the direct normal, diffuse horizontal and global horizontal
+// irradiance in W/m².
+type Irradiance struct {
+	DNI float64
+	DHI float64
+	GHI float64
+}
+
+// ClearSky returns the irradiance under a cloudless sky for the given sun
+// position, using the Meinel model for direct irradiance with the Kasten-Young
+// air mass and a diffuse fraction of 10%.
+func ClearSky(p Position) Irradiance {
+	if p.Elevation <= 0 {
+		return Irradiance{}
+	}
+	zenith := 90 - p.Elevation
+	airMass := 1 / (math.Cos(zenith*deg) + 0.50572*math.Pow(96.07995-zenith, -1.6364))
+	dni := solarConstant * math.Pow(0.7, math.Pow(airMass, 0.678))
+	dhi := 0.1 * dni
+	return Irradiance{DNI: dni, DHI: dhi, GHI: dni*math.Sin(p.Elevation*deg) + dhi}
+}
+
+// PlaneOfArray returns the irradiance in W/m² on a panel with the given tilt
+// from horizontal and azimuth (clockwise from north), using an isotropic sky.
+func PlaneOfArray(p Position, irr Irradiance, tilt, azimuth float64) float64 {
+	if p.Elevation <= 0 {
+		return 0
+	}
+	zenith := (90 - p.Elevation) * deg
+	t := tilt * deg
+	cosAOI := math.Cos(zenith)*math.Cos(t) + math.Sin(zenith)*math.Sin(t)*math.Cos((p.Azimuth-azimuth)*deg)
+	direct := irr.DNI * math.Max(0, cosAOI)
+	diffuse := irr.DHI * (1 + math.Cos(t)) / 2
+	reflected := irr.GHI * groundAlbedo * (1 - math.Cos(t)) / 2
+	return direct + diffuse + reflected
+}
@@ -0,0 +1,47 @@
+// Package sun computes the position of the sun and the irradiance a clear sky
+// delivers on a solar panel.
+package sun
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	deg = math.Pi / 180
+	rad = 180 / math.Pi
+)
+
+// Position is the position of the sun in degrees. Azimuth is measured
+// clockwise from north, so 180 is due south.
+type Position struct {
+	Elevation float64
+	Azimuth   float64
+}
+
+// PositionAt returns the position of the sun at time t for the given latitude
+// and longitude, using the NOAA general solar position equations. The result
+// is accurate to within a fraction of a degree, which is plenty for yield
+// estimates.
+func PositionAt(t time.Time, lat, lon float64) Position {
+	t = t.UTC()
+	hour := float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600
+	gamma := 2 * math.Pi / 365 * (float64(t.YearDay()-1) + (hour-12)/24)
+
+	eqTime := 229.18 * (0.000075 + 0.001868*math.Cos(gamma) - 0.032077*math.Sin(gamma) -
+		0.014615*math.Cos(2*gamma) - 0.040849*math.Sin(2*gamma))
+	decl := 0.006918 - 0.399912*math.Cos(gamma) + 0.070257*math.Sin(gamma) -
+		0.006758*math.Cos(2*gamma) + 0.000907*math.Sin(2*gamma) -
+		0.002697*math.Cos(3*gamma) + 0.00148*math.Sin(3*gamma)
+
+	trueSolarTime := hour*60 + eqTime + 4*lon
+	hourAngle := (trueSolarTime/4 - 180) * deg
+
+	phi := lat * deg
+	cosZenith := math.Sin(phi)*math.Sin(decl) + math.Cos(phi)*math.Cos(decl)*math.Cos(hourAngle)
+	cosZenith = math.Max(-1, math.Min(1, cosZenith))
+	zenith := math.Acos(cosZenith)
+
+	azimuth := math.Atan2(math.Sin(hourAngle), math.Cos(hourAngle)*math.Sin(phi)-math.Tan(decl)*math.Cos(phi))*rad + 180
+	return Position{Elevation: 90 - zenith*rad, Azimuth: math.Mod(azimuth+360, 360)}
+}
@@ -0,0 +1,87 @@
+package sun
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPositionAt(t *testing.T) {
+	// Solar noon in Amsterdam on the summer solstice: elevation is 90 - 52.37 + 23.44.
+	noon := time.Date(2024, 6, 21, 11, 40, 0, 0, time.UTC)
+	p := PositionAt(noon, 52.37, 4.89)
+	if math.Abs(p.Elevation-61.07) > 0.5 {
+		t.Errorf("Expected elevation near 61.07, got %f", p.Elevation)
+	}
+	if math.Abs(p.Azimuth-180) > 3 {
+		t.Errorf("Expected azimuth near 180, got %f", p.Azimuth)
+	}
+
+	morning := PositionAt(time.Date(2024, 6, 21, 6, 0, 0, 0, time.UTC), 52.37, 4.89)
+	if morning.Azimuth > 180 || morning.Elevation <= 0 {
+		t.Errorf("Expected the sun in the east above the horizon, got %+v", morning)
+	}
+
+	night := PositionAt(time.Date(2024, 6, 21, 23, 0, 0, 0, time.UTC), 52.37, 4.89)
+	if night.Elevation > 0 {
+		t.Errorf("Expected the sun below the horizon, got %+v", night)
+	}
+}
+
+func TestClearSky(t *testing.T) {
+	if irr := ClearSky(Position{Elevation: -5}); irr.GHI != 0 {
+		t.Errorf("Expected no irradiance at night, got %+v", irr)
+	}
+	irr := ClearSky(Position{Elevation: 60, Azimuth: 180})
+	if irr.GHI < 800 || irr.GHI > 1100 {
+		t.Errorf("Expected GHI between 800 and 1100, got %f", irr.GHI)
+	}
+
+	// A panel facing the sun receives more than a horizontal one.
+	p := Position{Elevation: 30, Azimuth: 180}
+	irr = ClearSky(p)
+	facing := PlaneOfArray(p, irr, 60, 180)
+	if facing <= irr.GHI {
+		t.Errorf("Expected %f to exceed GHI %f", facing, irr.GHI)
+	}
+	if away := PlaneOfArray(p, irr, 60, 0); away >= irr.GHI {
+		t.Errorf("Expected %f to be below GHI %f", away, irr.GHI)
+	}
+}
+
+func TestArrayEnergy(t *testing.T) {
+	a := Array{Lat: 52.37, Lon: 4.89, Tilt: 35, Azimuth: 180, KWp: 4}
+	day := time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC)
+	summer := a.Energy(day, day.AddDate(0, 0, 1), 10*time.Minute)
+	// A clear summer day yields roughly 6 to 8 kWh per kWp in the Netherlands.
+	if summer < 4*5000 || summer > 4*9000 {
+		t.Errorf("Expected 20 to 36 kWh on a clear summer day, got %f Wh", summer)
+	}
+	day = time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC)
+	if winter := a.Energy(day, day.AddDate(0, 0, 1), 10*time.Minute); winter >= summer/2 {
+		t.Errorf("Expected winter yield %f to be well below summer yield %f", winter, summer)
+	}
+	if p := a.Power(time.Date(2024, 6, 21, 23, 0, 0, 0, time.UTC)); p != 0 {
+		t.Errorf("Expected no power at night, got %f", p)
+	}
+}
+
+func TestDaylight(t *testing.T) {
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skip("no time zone data")
+	}
+	rise, set, ok := Daylight(time.Date(2024, 6, 21, 12, 0, 0, 0, amsterdam), 52.37, 4.89)
+	// Sunrise is at 05:18 and sunset at 22:06 local time.
+	if !ok || rise.In(amsterdam).Format("15:04") < "05:13" || rise.In(amsterdam).Format("15:04") > "05:23" {
+		t.Errorf("Expected sunrise around 05:18, got %s %v", rise.In(amsterdam), ok)
+	}
+	if set.In(amsterdam).Format("15:04") < "22:01" || set.In(amsterdam).Format("15:04") > "22:11" {
+		t.Errorf("Expected sunset around 22:06, got %s", set.In(amsterdam))
+	}
+
+	// Polar night in Tromsø.
+	if _, _, ok := Daylight(time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC), 69.65, 18.96); ok {
+		t.Errorf("Expected no sunrise in polar night")
+	}
+}
@@ -0,0 +1,102 @@
+package tariff
+
+import (
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+// Saved and Earned are the kinds of money a Ledger keeps.
+const (
+	Saved  = "saved"
+	Earned = "earned"
+)
+
+// Ledger prices the energy of a site poll by poll and keeps the money per day
+// in the site database. Each poll values the energy produced and exported
+// since the previous one at the prices of that moment, which is what makes
+// time-of-use rates work with providers that only report daily totals.
+type Ledger struct {
+	tariff *Tariff
+	db     *models.DataBase
+}
+
+func NewLedger(tariff *Tariff, db *models.DataBase) *Ledger {
+	return &Ledger{tariff: tariff, db: db}
+}
+
+// Totals holds the money of a kind for the current day, month and year.
+type Totals struct {
+	Today float64
+	Month float64
+	Year  float64
+}
+
+func (l *Ledger) Currency() string {
+	return l.tariff.Currency
+}
+
+// delta returns the increase of a counter since it was last saved and saves
+// the new value. Counters that reset every day start from zero on a new day or
+// once they drop; portals often report yesterday's total for a while after
+// midnight, so on a new day a value equal to yesterday's last one is stale,
+// counts nothing and leaves the counter on yesterday. Lifetime counters only
+// count from their first reading.
+func (l *Ledger) delta(name, day string, value float64, daily bool) (float64, error) {
+	lastDay, last, ok, err := l.db.GetCounter(name)
+	if err != nil {
+		return 0, err
+	}
+	if daily && ok && lastDay != day && value == last {
+		return 0, nil
+	}
+	if err := l.db.SaveCounter(name, day, value); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case daily && (!ok || lastDay != day || value < last):
+		return value, nil
+	case !ok || value < last:
+		return 0, nil
+	}
+	return value - last, nil
+}
+
+// Record values the energy of status since the previous poll and adds it to
+// the money of today.
+func (l *Ledger) Record(status *models.SolarStatus, now time.Time) error {
+	day := now.Format(dateFormat)
+
+	production, err := l.delta("production_today", day, status.EnergyToday, true)
+	if err != nil {
+		return err
+	}
+	export := 0.0
+	if status.Grid != nil && status.Grid.EnergyExport != nil {
+		export, err = l.delta("grid_export_total", day, *status.Grid.EnergyExport, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	saved, earned := l.tariff.Value(now, production, export)
+	if err := l.db.AddMoney(day, Saved, saved); err != nil {
+		return err
+	}
+	return l.db.AddMoney(day, Earned, earned)
+}
+
+// Totals returns the money of kind for the current day, month and year.
+func (l *Ledger) Totals(kind string, now time.Time) (Totals, error) {
+	var totals Totals
+	var err error
+	if totals.Today, err = l.db.GetMoneyTotal(kind, now.Format(dateFormat)); err != nil {
+		return totals, err
+	}
+	if totals.Month, err = l.db.GetMoneyTotal(kind, now.Format("2006-01")); err != nil {
+		return totals, err
+	}
+	totals.Year, err = l.db.GetMoneyTotal(kind, now.Format("2006"))
+	return totals, err
+}
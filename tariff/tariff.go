@@ -0,0 +1,163 @@
+// Package tariff prices the energy of a site with a configurable tariff, to
+// compute the money saved by consuming solar energy and earned by exporting
+// it, independent of what the vendor portal reports.
+package tariff
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const dateFormat = "2006-01-02"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Tariff is a tariff schedule. Prices are in Currency per kWh. The periods
+// are date ranges with their own prices, for price changes over time; the
+// first period containing a date applies.
+type Tariff struct {
+	Currency string   `yaml:"currency"`
+	Periods  []Period `yaml:"periods"`
+}
+
+// Period holds the prices from From up to, not including, To. Either may be
+// empty for an open range. With NetMetering exports offset imports, so they
+// are worth the import price instead of the export price.
+type Period struct {
+	From        string   `yaml:"from"`
+	To          string   `yaml:"to"`
+	Import      Schedule `yaml:"import"`
+	Export      Schedule `yaml:"export"`
+	NetMetering bool     `yaml:"net_metering"`
+}
+
+// Schedule is a flat price, optionally overridden by time-of-use rates; the
+// first matching rate applies.
+type Schedule struct {
+	Price float64 `yaml:"price"`
+	Rates []Rate  `yaml:"rates"`
+}
+
+// Rate is a price that applies between From and To, as "15:04" in local time,
+// on the given days (mon, tue, ...), or every day when Days is empty. A rate
+// with To before From runs past midnight.
+type Rate struct {
+	Name  string   `yaml:"name"`
+	Days  []string `yaml:"days"`
+	From  string   `yaml:"from"`
+	To    string   `yaml:"to"`
+	Price float64  `yaml:"price"`
+}
+
+// Validate checks the dates, days and times of the tariff.
+func (t *Tariff) Validate() error {
+	if len(t.Periods) == 0 {
+		return fmt.Errorf("tariff has no periods")
+	}
+	for _, p := range t.Periods {
+		for _, date := range []string{p.From, p.To} {
+			if date == "" {
+				continue
+			}
+			if _, err := time.Parse(dateFormat, date); err != nil {
+				return fmt.Errorf("invalid date [%s], expected YYYY-MM-DD", date)
+			}
+		}
+		for _, s := range []Schedule{p.Import, p.Export} {
+			for _, r := range s.Rates {
+				for _, day := range r.Days {
+					if _, ok := weekdays[strings.ToLower(day)]; !ok {
+						return fmt.Errorf("invalid day [%s] in rate [%s]", day, r.Name)
+					}
+				}
+				for _, clock := range []string{r.From, r.To} {
+					if _, err := parseClock(clock); err != nil {
+						return fmt.Errorf("invalid time [%s] in rate [%s], expected HH:MM", clock, r.Name)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock returns a "15:04" time as an offset from midnight.
+func parseClock(clock string) (time.Duration, error) {
+	c, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(c.Hour())*time.Hour + time.Duration(c.Minute())*time.Minute, nil
+}
+
+// period returns the period that applies at t.
+func (t *Tariff) period(at time.Time) (*Period, bool) {
+	day := at.Format(dateFormat)
+	for i, p := range t.Periods {
+		// The dates share a format, so they compare as strings.
+		if (p.From == "" || day >= p.From) && (p.To == "" || day < p.To) {
+			return &t.Periods[i], true
+		}
+	}
+	return nil, false
+}
+
+// matches reports whether the rate applies at t.
+func (r Rate) matches(at time.Time) bool {
+	if len(r.Days) > 0 {
+		found := false
+		for _, day := range r.Days {
+			found = found || weekdays[strings.ToLower(day)] == at.Weekday()
+		}
+		if !found {
+			return false
+		}
+	}
+	from, _ := parseClock(r.From)
+	to, _ := parseClock(r.To)
+	now := time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+	if from <= to {
+		return now >= from && now < to
+	}
+	return now >= from || now < to
+}
+
+// PriceAt returns the price of the schedule at t.
+func (s Schedule) PriceAt(at time.Time) float64 {
+	for _, r := range s.Rates {
+		if r.matches(at) {
+			return r.Price
+		}
+	}
+	return s.Price
+}
+
+// Value returns the money saved by self-consuming and earned by exporting the
+// energy produced around t, in Wh. When the site has no grid readings all
+// production is taken as self-consumed.
+func (t *Tariff) Value(at time.Time, production, export float64) (saved, earned float64) {
+	p, ok := t.period(at)
+	if !ok {
+		return 0, 0
+	}
+	importPrice := p.Import.PriceAt(at)
+	exportPrice := p.Export.PriceAt(at)
+	if p.NetMetering {
+		exportPrice = importPrice
+	}
+
+	selfConsumed := production - export
+	if selfConsumed < 0 {
+		selfConsumed = 0
+	}
+	return selfConsumed / 1000 * importPrice, export / 1000 * exportPrice
+}
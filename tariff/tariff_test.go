@@ -0,0 +1,149 @@
+package tariff
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rvben/solar_exporter/models"
+)
+
+func testTariff() *Tariff {
+	return &Tariff{
+		Currency: "EUR",
+		Periods: []Period{
+			{
+				To:     "2024-01-01",
+				Import: Schedule{Price: 0.40},
+				Export: Schedule{Price: 0.10},
+			},
+			{
+				From: "2024-01-01",
+				Import: Schedule{Price: 0.30, Rates: []Rate{
+					{Name: "peak", Days: []string{"mon", "tue", "wed", "thu", "fri"}, From: "07:00", To: "23:00", Price: 0.35},
+					{Name: "night", From: "23:00", To: "07:00", Price: 0.20},
+				}},
+				Export: Schedule{Price: 0.05},
+			},
+		},
+	}
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestPrices(t *testing.T) {
+	tariff := testTariff()
+	if err := tariff.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at    time.Time
+		price float64
+	}{
+		{time.Date(2023, 12, 31, 12, 0, 0, 0, time.Local), 0.40},
+		{time.Date(2024, 6, 3, 12, 0, 0, 0, time.Local), 0.35}, // Monday
+		{time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local), 0.30}, // Saturday
+		{time.Date(2024, 6, 1, 23, 30, 0, 0, time.Local), 0.20},
+		{time.Date(2024, 6, 3, 6, 59, 0, 0, time.Local), 0.20},
+	}
+	for _, test := range tests {
+		p, _ := tariff.period(test.at)
+		if got := p.Import.PriceAt(test.at); got != test.price {
+			t.Errorf("Expected %f at %s, got %f", test.price, test.at, got)
+		}
+	}
+}
+
+func TestValue(t *testing.T) {
+	tariff := testTariff()
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+
+	saved, earned := tariff.Value(at, 3000, 1000)
+	if !almostEqual(saved, 0.60) || !almostEqual(earned, 0.05) {
+		t.Errorf("Expected 0.60 saved and 0.05 earned, got %f and %f", saved, earned)
+	}
+
+	tariff.Periods[1].NetMetering = true
+	_, earned = tariff.Value(at, 3000, 1000)
+	if !almostEqual(earned, 0.30) {
+		t.Errorf("Expected exports at the import price with net metering, got %f", earned)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tariff := range []Tariff{
+		{},
+		{Periods: []Period{{From: "01-01-2024"}}},
+		{Periods: []Period{{Import: Schedule{Rates: []Rate{{Days: []string{"monday"}, From: "07:00", To: "08:00"}}}}}},
+		{Periods: []Period{{Export: Schedule{Rates: []Rate{{From: "7", To: "08:00"}}}}}},
+	} {
+		if err := tariff.Validate(); err == nil {
+			t.Errorf("Expected an error for %+v", tariff)
+		}
+	}
+}
+
+// exported returns a grid status with an export counter of wh.
+func exported(wh float64) *models.GridStatus {
+	return &models.GridStatus{EnergyExport: &wh}
+}
+
+func TestLedger(t *testing.T) {
+	db, err := models.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.DB.Close()
+	ledger := NewLedger(testTariff(), db)
+
+	// Saturday: 1 kWh at night, then 2 kWh at midday of which 1 kWh exported.
+	night := time.Date(2024, 6, 1, 6, 0, 0, 0, time.Local)
+	noon := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	if err := ledger.Record(&models.SolarStatus{EnergyToday: 1000, Grid: exported(5000)}, night); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.Record(&models.SolarStatus{EnergyToday: 3000, Grid: exported(6000)}, noon); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := ledger.Totals(Saved, noon)
+	earned, _ := ledger.Totals(Earned, noon)
+	if !almostEqual(saved.Today, 0.20+0.30) || !almostEqual(earned.Today, 0.05) {
+		t.Errorf("Expected 0.50 saved and 0.05 earned, got %+v and %+v", saved, earned)
+	}
+
+	// Yesterday's total reported after midnight counts nothing, and the daily
+	// counter starts over once it drops.
+	midnight := time.Date(2024, 6, 2, 0, 5, 0, 0, time.Local)
+	ledger.Record(&models.SolarStatus{EnergyToday: 3000, Grid: exported(6000)}, midnight)
+	if saved, _ = ledger.Totals(Saved, midnight); saved.Today != 0 {
+		t.Errorf("Expected nothing saved for a stale total, got %+v", saved)
+	}
+	sunday := time.Date(2024, 6, 2, 12, 0, 0, 0, time.Local)
+	ledger.Record(&models.SolarStatus{EnergyToday: 500, Grid: exported(6000)}, sunday)
+	saved, _ = ledger.Totals(Saved, sunday)
+	if !almostEqual(saved.Today, 0.15) || !almostEqual(saved.Month, 0.65) {
+		t.Errorf("Expected 0.15 today and 0.65 this month, got %+v", saved)
+	}
+
+	// A restart after a low-yield day counts the first reading of the next
+	// day in full, although it is above yesterday's total.
+	ledger.Record(&models.SolarStatus{EnergyToday: 800, Grid: exported(6000)}, sunday.Add(2*time.Hour))
+	monday := time.Date(2024, 6, 3, 13, 0, 0, 0, time.Local)
+	ledger.Record(&models.SolarStatus{EnergyToday: 2000, Grid: exported(6000)}, monday)
+	if saved, _ = ledger.Totals(Saved, monday); !almostEqual(saved.Today, 0.70) {
+		t.Errorf("Expected 0.70 saved after a restart, got %+v", saved)
+	}
+
+	// A stale total leaves the counter on yesterday, so the first new reading
+	// counts in full even when it is above yesterday's total.
+	tuesday := time.Date(2024, 6, 4, 0, 5, 0, 0, time.Local)
+	ledger.Record(&models.SolarStatus{EnergyToday: 2000, Grid: exported(6000)}, tuesday)
+	ledger.Record(&models.SolarStatus{EnergyToday: 2500, Grid: exported(6000)}, tuesday.Add(12*time.Hour))
+	if saved, _ = ledger.Totals(Saved, tuesday); !almostEqual(saved.Today, 0.875) {
+		t.Errorf("Expected 0.875 saved after a stale total, got %+v", saved)
+	}
+}